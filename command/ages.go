@@ -0,0 +1,127 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ageBuckets are the upper bounds of the age histogram buckets.  A final bucket holds everything older.
+var ageBuckets = []struct {
+	name string
+	max  time.Duration
+}{
+	{"<1h", time.Hour},
+	{"<1d", 24 * time.Hour},
+	{"<1w", 7 * 24 * time.Hour},
+}
+
+// unreadAges describes how long unread messages have been waiting
+type unreadAges struct {
+	Oldest           time.Time   `json:"oldest"`
+	Newest           time.Time   `json:"newest"`
+	OldestAgeSeconds int64       `json:"oldestAgeSeconds"`
+	NewestAgeSeconds int64       `json:"newestAgeSeconds"`
+	Histogram        []ageBucket `json:"histogram"`
+}
+
+// ageBucket is the number of unread messages in an age range
+type ageBucket struct {
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+// findAges finds the ages of the unread messages in ids, which must be ordered newest first.
+// Since the messages are ordered the histogram is found with a binary search for each bucket boundary,
+// so only a handful of messages need to be fetched however many are unread.
+func findAges(fetcher *messageFetcher, user string, ids []string, now time.Time) (*unreadAges, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	dates := map[int]time.Time{}
+	var fetchErr error
+	dateAt := func(index int) time.Time {
+		date, ok := dates[index]
+		if ok || fetchErr != nil {
+			return date
+		}
+
+		result := fetcher.fetch(user, []string{ids[index]})[0]
+		if result.err != nil {
+			fetchErr = fmt.Errorf("Unable to get message %s. %v", ids[index], result.err)
+			return date
+		}
+
+		date = newMessageSummary(result.message).Date
+		dates[index] = date
+		return date
+	}
+
+	ages := &unreadAges{Newest: dateAt(0), Oldest: dateAt(len(ids) - 1)}
+	ages.NewestAgeSeconds = int64(now.Sub(ages.Newest) / time.Second)
+	ages.OldestAgeSeconds = int64(now.Sub(ages.Oldest) / time.Second)
+	start := 0
+	for _, bucket := range ageBuckets {
+		cutoff := now.Add(-bucket.max)
+		end := sort.Search(len(ids), func(index int) bool { return !dateAt(index).After(cutoff) })
+		ages.Histogram = append(ages.Histogram, ageBucket{Bucket: bucket.name, Count: end - start})
+		start = end
+	}
+
+	ages.Histogram = append(ages.Histogram, ageBucket{Bucket: "older", Count: len(ids) - start})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return ages, nil
+}
+
+// mergeAges combines the ages of several counts, returning nil if none of them have ages
+func mergeAges(counts []unreadCount) *unreadAges {
+	var merged *unreadAges
+	for _, count := range counts {
+		if count.Ages == nil {
+			continue
+		}
+
+		if merged == nil {
+			copied := *count.Ages
+			copied.Histogram = append([]ageBucket{}, count.Ages.Histogram...)
+			merged = &copied
+			continue
+		}
+
+		if count.Ages.Oldest.Before(merged.Oldest) {
+			merged.Oldest = count.Ages.Oldest
+			merged.OldestAgeSeconds = count.Ages.OldestAgeSeconds
+		}
+
+		if count.Ages.Newest.After(merged.Newest) {
+			merged.Newest = count.Ages.Newest
+			merged.NewestAgeSeconds = count.Ages.NewestAgeSeconds
+		}
+
+		for index := range merged.Histogram {
+			merged.Histogram[index].Count += count.Ages.Histogram[index].Count
+		}
+	}
+
+	return merged
+}
+
+// formatAges describes ages in two lines of text
+func formatAges(ages *unreadAges) string {
+	buckets := make([]string, 0, len(ages.Histogram))
+	for _, bucket := range ages.Histogram {
+		buckets = append(buckets, fmt.Sprintf("%s: %d", bucket.Bucket, bucket.Count))
+	}
+
+	return fmt.Sprintf(
+		"oldest: %s newest: %s\n%s\n",
+		formatAge(time.Duration(ages.OldestAgeSeconds)*time.Second),
+		formatAge(time.Duration(ages.NewestAgeSeconds)*time.Second),
+		strings.Join(buckets, " "),
+	)
+}
@@ -0,0 +1,106 @@
+package command_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdCheckAges(t *testing.T) {
+	mock := newAgesMock(t, 10*time.Minute, 2*time.Hour, 5*time.Hour, 3*24*time.Hour, 30*24*time.Hour)
+	output, err := runAgesCheck(t, mock, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "5\noldest: 30d newest: 10m\n<1h: 1 <1d: 2 <1w: 1 older: 1\n", output)
+}
+
+func TestCmdCheckAgesJSON(t *testing.T) {
+	mock := newAgesMock(t, 2*time.Hour, 8*24*time.Hour)
+	output, err := runAgesCheck(t, mock, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	var result struct {
+		Total  int
+		Counts []struct {
+			Account string
+			Label   string
+			Unread  int
+			Ages    struct {
+				OldestAgeSeconds int64
+				NewestAgeSeconds int64
+				Histogram        []struct {
+					Bucket string
+					Count  int
+				}
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal([]byte(output), &result))
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 1, len(result.Counts))
+	assert.Equal(t, "me@example.com", result.Counts[0].Account)
+	assert.Equal(t, "INBOX", result.Counts[0].Label)
+	assert.InDelta(t, int64((8*24*time.Hour+30*time.Second)/time.Second), result.Counts[0].Ages.OldestAgeSeconds, 5)
+	assert.InDelta(t, int64((2*time.Hour+30*time.Second)/time.Second), result.Counts[0].Ages.NewestAgeSeconds, 5)
+	histogram := []string{}
+	for _, bucket := range result.Counts[0].Ages.Histogram {
+		histogram = append(histogram, fmt.Sprintf("%s=%d", bucket.Bucket, bucket.Count))
+	}
+
+	assert.Equal(t, []string{"<1h=0", "<1d=1", "<1w=0", "older=1"}, histogram)
+}
+
+func TestCmdCheckAgesFetchesFewMessages(t *testing.T) {
+	ages := []time.Duration{}
+	for index := 0; index < 200; index++ {
+		ages = append(ages, time.Duration(index)*time.Hour)
+	}
+
+	mock := newAgesMock(t, ages...)
+	output, err := runAgesCheck(t, mock, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "200\noldest: 8d newest: 0m\n<1h: 1 <1d: 23 <1w: 144 older: 32\n", output)
+	assert.True(t, mock.messageRequests < 30, "fetched %d messages", mock.messageRequests)
+}
+
+func TestCmdCheckAgesEmptyInbox(t *testing.T) {
+	output, err := runAgesCheck(t, newAgesMock(t), func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "0\n", output)
+}
+
+func TestCmdCheckAgesMissingMessage(t *testing.T) {
+	mock := newAgesMock(t, time.Hour, 2*time.Hour)
+	delete(mock.messages, "m1")
+	_, err := runAgesCheck(t, mock, func(*flag.FlagSet) {})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unable to get message m1. googleapi: got HTTP response code 404")
+}
+
+// newAgesMock returns a mock API with an unread message received each age ago, ordered newest first like the real API
+func newAgesMock(t *testing.T, ages ...time.Duration) *mockGmail {
+	now := time.Now()
+	ids := []string{}
+	messages := map[string]*gmail.Message{}
+	for index, age := range ages {
+		id := fmt.Sprintf("m%d", index)
+		ids = append(ids, id)
+		messages[id] = newListTestMessage(id, "alice@example.com", id, "", summaryDate(now, age), "INBOX", "UNREAD")
+	}
+
+	return &mockGmail{t: t, checks: [][]string{ids}, messages: messages}
+}
+
+// runAgesCheck runs CmdCheck with ages enabled against mock and returns the output after the OAuth prompt
+func runAgesCheck(t *testing.T, mock *mockGmail, setFlags func(*flag.FlagSet)) (string, error) {
+	return runGmailCheck(t, mock, func(set *flag.FlagSet) {
+		set.Bool("ages", true, "doc")
+		setFlags(set)
+	})
+}
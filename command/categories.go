@@ -0,0 +1,68 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// categoryPrimary is the label of the Primary inbox tab
+const categoryPrimary = "CATEGORY_PERSONAL"
+
+// inboxCategories are the labels of the inbox tabs, in the order Gmail shows them, along with the names of the tabs
+var inboxCategories = []struct {
+	label string
+	name  string
+}{
+	{categoryPrimary, "primary"},
+	{"CATEGORY_SOCIAL", "social"},
+	{"CATEGORY_PROMOTIONS", "promotions"},
+	{"CATEGORY_UPDATES", "updates"},
+	{"CATEGORY_FORUMS", "forums"},
+}
+
+// countCategories returns the number of unread messages under label in each inbox tab
+func countCategories(srv *gmail.Service, user, label string) (map[string]int, error) {
+	categories := make(map[string]int, len(inboxCategories))
+	for _, category := range inboxCategories {
+		ids, err := listUnreadLabels(srv, user, []string{label, category.label}, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		categories[category.name] = len(ids)
+	}
+
+	return categories, nil
+}
+
+// mergeCategories adds up the category counts of several counts, returning nil if none of them have categories
+func mergeCategories(counts []unreadCount) map[string]int {
+	var merged map[string]int
+	for _, count := range counts {
+		if count.Categories == nil {
+			continue
+		}
+
+		if merged == nil {
+			merged = map[string]int{}
+		}
+
+		for name, unread := range count.Categories {
+			merged[name] += unread
+		}
+	}
+
+	return merged
+}
+
+// formatCategories describes the category counts in a line of text
+func formatCategories(categories map[string]int) string {
+	parts := make([]string, 0, len(inboxCategories))
+	for _, category := range inboxCategories {
+		parts = append(parts, fmt.Sprintf("%s: %d", category.name, categories[category.name]))
+	}
+
+	return strings.Join(parts, " ") + "\n"
+}
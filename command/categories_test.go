@@ -0,0 +1,85 @@
+package command_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var categoryTestMessages = map[string]*gmail.Message{
+	"a": newListTestMessage("a", "alice@example.com", "Lunch?", "", 0, "INBOX", "UNREAD", "CATEGORY_PERSONAL"),
+	"b": newListTestMessage("b", "bob@example.com", "Dinner?", "", 0, "INBOX", "UNREAD", "CATEGORY_PERSONAL"),
+	"c": newListTestMessage("c", "deals@example.com", "50% off", "", 0, "INBOX", "UNREAD", "CATEGORY_PROMOTIONS"),
+	"d": newListTestMessage("d", "ci@example.com", "Build failed", "", 0, "INBOX", "UNREAD", "CATEGORY_UPDATES"),
+	"e": newListTestMessage("e", "friends@example.com", "New follower", "", 0, "INBOX", "UNREAD", "CATEGORY_SOCIAL"),
+}
+
+func TestCmdCheckCategories(t *testing.T) {
+	output, err := runCategoryCheck(t, func(set *flag.FlagSet) {
+		set.Bool("categories", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "5\nprimary: 2 social: 1 promotions: 1 updates: 1 forums: 0\n", output)
+}
+
+func TestCmdCheckCategoriesJSON(t *testing.T) {
+	output, err := runCategoryCheck(t, func(set *flag.FlagSet) {
+		set.Bool("categories", true, "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`{"total":5,"counts":[{"account":"me@example.com","label":"INBOX","unread":5,"messages":5,`+
+			`"categories":{"forums":0,"primary":2,"promotions":1,"social":1,"updates":1}}]}`+"\n",
+		output,
+	)
+}
+
+func TestCmdCheckPrimary(t *testing.T) {
+	output, err := runCategoryCheck(t, func(set *flag.FlagSet) {
+		set.Bool("primary", true, "doc")
+		set.String("count", "both", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "2 (2 threads)\n", output)
+}
+
+func TestCmdCheckCategoriesTextfile(t *testing.T) {
+	textfileDir := filepath.Join(os.TempDir(), "testUnreadCheckerTextfile")
+	assert.Nil(t, os.MkdirAll(textfileDir, 0777))
+	defer removeFile(t, textfileDir)
+	_, err := runCategoryCheck(t, func(set *flag.FlagSet) {
+		set.Bool("categories", true, "doc")
+		set.Bool("primary", true, "doc")
+		set.String("textfileDir", textfileDir, "doc")
+	})
+	assert.Nil(t, err)
+	lines := readTextfile(t, textfileDir)
+	assert.Equal(
+		t,
+		[]string{
+			"# HELP unreadchecker_unread_messages Number of unread messages.",
+			"# TYPE unreadchecker_unread_messages gauge",
+			`unreadchecker_unread_messages{account="me@example.com",label="INBOX"} 2`,
+			"# HELP unreadchecker_unread_category_messages Number of unread messages in each inbox tab.",
+			"# TYPE unreadchecker_unread_category_messages gauge",
+			`unreadchecker_unread_category_messages{account="me@example.com",label="INBOX",category="primary"} 2`,
+			`unreadchecker_unread_category_messages{account="me@example.com",label="INBOX",category="social"} 1`,
+			`unreadchecker_unread_category_messages{account="me@example.com",label="INBOX",category="promotions"} 1`,
+			`unreadchecker_unread_category_messages{account="me@example.com",label="INBOX",category="updates"} 1`,
+			`unreadchecker_unread_category_messages{account="me@example.com",label="INBOX",category="forums"} 0`,
+		},
+		lines[:10],
+	)
+}
+
+// runCategoryCheck runs CmdCheck against a mock API with unread messages spread across the inbox tabs
+func runCategoryCheck(t *testing.T, setFlags func(*flag.FlagSet)) (string, error) {
+	return runGmailCheck(t, &mockGmail{t: t, checks: [][]string{{"e", "d", "c", "b", "a"}}, messages: categoryTestMessages}, setFlags)
+}
@@ -2,6 +2,11 @@ package command
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
 
 	gmail "google.golang.org/api/gmail/v1"
 
@@ -12,6 +17,37 @@ import (
 // BasePath allows overriding the gmail API base path for testing
 var BasePath string
 
+const (
+	defaultUser  = "me"
+	defaultLabel = "INBOX"
+	unreadQuery  = "label:unread"
+)
+
+// What is counted by --count
+const (
+	countMessages = "messages"
+	countThreads  = "threads"
+	countBoth     = "both"
+)
+
+// unreadCount is the number of unread messages under a label for an account
+type unreadCount struct {
+	Account string `json:"account"`
+	Label   string `json:"label"`
+	// Unread is the number of unread messages or threads, depending on what is being counted
+	Unread     int            `json:"unread"`
+	Messages   int            `json:"messages"`
+	Threads    *int           `json:"threads,omitempty"`
+	Categories map[string]int `json:"categories,omitempty"`
+	Ages       *unreadAges    `json:"ages,omitempty"`
+	ids        []string
+	// delegated is whether the count is for a mailbox other than the authenticated user's
+	delegated bool
+}
+
+// MaxWatchIterations allows limiting the number of checks made in watch mode for testing
+var MaxWatchIterations int
+
 // CmdCheck checks the inbox for unread messages
 func CmdCheck(cmdBuilder runner.Builder) func(c *cli.Context) error {
 	return func(c *cli.Context) error {
@@ -19,63 +55,600 @@ func CmdCheck(cmdBuilder runner.Builder) func(c *cli.Context) error {
 			return cli.NewExitError("Usage: \"unreadChecker\"", 1)
 		}
 
-		err := checkFlags(c)
+		accounts, err := newAccounts(c, cmdBuilder)
 		if err != nil {
 			return err
 		}
 
-		tokenClient, err := NewClient(c.String("credentialFile"), c.String("tokenFile"), cmdBuilder)
+		useGmail := gmailConfigured(c)
+		if useGmail {
+			err = checkFlags(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch c.String("count") {
+		case "", countMessages, countThreads, countBoth:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid count: %s", c.String("count")), 1)
+		}
+
+		out, err := newFormatter(c, c.Bool("watch"))
 		if err != nil {
-			return fmt.Errorf("Could not initialize token client: %v", err)
+			return err
+		}
+
+		notifier, err := newNotifier(c, cmdBuilder)
+		if err != nil {
+			return err
+		}
+
+		mqtt, err := newMQTTPublisher(c)
+		if err != nil {
+			return err
+		}
+
+		errWriter := &syncWriter{writer: c.App.ErrWriter}
+		hooks, err := newHookRunner(c, cmdBuilder, errWriter)
+		if err != nil {
+			return err
+		}
+
+		checker := &checker{
+			context:    c,
+			cmdBuilder: cmdBuilder,
+			errWriter:  errWriter,
+			notifier:   notifier,
+			hooks:      hooks,
+			webhooks:   newWebhookSender(c, errWriter),
+			mqtt:       mqtt,
+			gmail:      useGmail,
+			user:       gmailUser(c),
+			folders:    accounts,
+		}
+
+		if c.Bool("watch") {
+			return checker.watch(out)
 		}
 
-		httpClient, err := tokenClient.GetHTTPClient(c.App.Writer)
+		counts, err := checker.check()
+		return checker.report(out, counts, err)
+	}
+}
+
+// checker holds the state that is shared between checks
+type checker struct {
+	context    *cli.Context
+	cmdBuilder runner.Builder
+	started    bool
+	errWriter  io.Writer
+	notifier   *notifier
+	hooks      *hookRunner
+	webhooks   *webhookSender
+	mqtt       *mqttPublisher
+	previous   []unreadCount
+	// gmail is whether the Gmail account is checked
+	gmail bool
+	// user is the Gmail mailbox that is checked, which is the authenticated user's unless --user names another
+	user string
+	// account is the Gmail account, which is connected on the first check
+	account *gmailProvider
+	// folders are checked after the Gmail account
+	folders []providerFolder
+}
+
+// syncWriter serializes writes from concurrent goroutines
+type syncWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (writer *syncWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.writer.Write(p)
+}
+
+// watch checks repeatedly, reporting errors instead of exiting on them
+func (checker *checker) watch(out formatter) error {
+	interval := checker.context.Duration("interval")
+	var changes chan struct{}
+	if checker.context.Bool("push") {
+		changes = make(chan struct{}, 1)
+		for _, account := range checker.folders {
+			if pusher, ok := account.provider.(pushProvider); ok {
+				defer pusher.watch(account.folder, changes, checker.errWriter)()
+			}
+		}
+	}
+
+	for iteration := 0; MaxWatchIterations == 0 || iteration < MaxWatchIterations; iteration++ {
+		if iteration != 0 {
+			waitForChange(interval, changes)
+		}
+
+		counts, checkErr := checker.check()
+		err := checker.report(out, counts, checkErr)
 		if err != nil {
-			return fmt.Errorf("Could not get OAuth token: %v", err)
+			fmt.Fprintf(checker.errWriter, "%v\n", err)
+		}
+
+		if checker.hooks != nil {
+			checker.hooks.fire(checker.previous, counts, checkErr)
+		}
+
+		if checkErr != nil {
+			continue
+		}
+
+		if checker.webhooks != nil {
+			checker.webhooks.send(checker.previous, counts)
 		}
 
-		srv, _ := gmail.New(httpClient)
-		if BasePath != "" {
-			srv.BasePath = BasePath
+		if checker.notifier != nil && checker.previous != nil {
+			err = checker.notifier.notify(checker.gmailAccounts(), checker.previous, counts)
+			if err != nil {
+				fmt.Fprintf(checker.errWriter, "%v\n", err)
+			}
 		}
 
-		user := "me"
+		checker.previous = counts
+	}
+
+	if checker.hooks != nil {
+		checker.hooks.wait()
+	}
+
+	if checker.webhooks != nil {
+		checker.webhooks.wait()
+	}
+
+	return nil
+}
 
-		total := 0
+// waitForChange waits for interval to pass or for a change to be pushed, whichever comes first
+func waitForChange(interval time.Duration, changes <-chan struct{}) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-changes:
+	}
+}
 
-		resp, err := srv.Users.Messages.List(user).LabelIds("INBOX").Q("label:unread").Do()
+func (checker *checker) check() ([]unreadCount, error) {
+	counts := []unreadCount{}
+	if checker.gmail {
+		count, err := checker.checkGmail()
 		if err != nil {
-			return fmt.Errorf("Unable to check inbox. %v", err)
+			return nil, err
 		}
 
-		total += len(resp.Messages)
+		counts = append(counts, count)
+	}
 
-		nextPageToken := resp.NextPageToken
+	for _, account := range checker.folders {
+		if gmailAccount, ok := account.provider.(gmailProvider); ok {
+			count, err := checker.checkGmailAccount(gmailAccount, account.folder)
+			if err != nil {
+				return nil, err
+			}
 
-		for nextPageToken != "" {
-			resp, err = srv.Users.Messages.List(user).LabelIds("INBOX").Q("label:unread").PageToken(nextPageToken).Do()
+			counts = append(counts, count)
+			continue
+		}
+
+		folders := []string{account.folder}
+		if account.folder == "" {
+			var err error
+			folders, err = account.provider.folders()
 			if err != nil {
-				return fmt.Errorf("Unable to check inbox. %v", err)
+				return nil, err
 			}
+		}
+
+		for _, folder := range folders {
+			unread, err := account.provider.countUnread(folder)
+			if err != nil {
+				return nil, err
+			}
+
+			count := unreadCount{Account: account.provider.name(), Label: folder, Unread: unread, Messages: unread}
+			if checker.findsNewMessages() {
+				count.ids, err = account.provider.listUnread(folder, 0)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if counter, ok := account.provider.(threadCounter); ok {
+				err = checker.addThreads(&count, func() (int, error) { return counter.countUnreadThreads(folder) })
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			counts = append(counts, count)
+		}
+	}
+
+	return counts, nil
+}
+
+func (checker *checker) checkGmail() (unreadCount, error) {
+	if checker.account == nil {
+		srv, httpClient, err := getService(checker.context, checker.cmdBuilder)
+		if err != nil {
+			return unreadCount{}, err
+		}
+
+		address, err := gmailAddress(srv, checker.user)
+		if err != nil {
+			return unreadCount{}, err
+		}
 
-			total += len(resp.Messages)
+		checker.account = &gmailProvider{
+			srv:     srv,
+			user:    checker.user,
+			address: address,
+			fetcher: newMessageFetcher(srv, httpClient, "From", "Subject", "Date"),
+		}
+	}
+
+	count, err := checker.checkGmailAccount(*checker.account, defaultLabel)
+	count.delegated = checker.user != defaultUser
+	return count, err
+}
+
+// checkGmailAccount counts the unread messages under label in a Gmail account, along with the Gmail only counts that are enabled
+func (checker *checker) checkGmailAccount(account gmailProvider, label string) (unreadCount, error) {
+	labels := countedLabels(checker.context, label)
+	ids, err := listUnreadLabels(account.srv, account.user, labels, 0)
+	if err != nil {
+		return unreadCount{}, err
+	}
+
+	count := unreadCount{Account: account.name(), Label: label, Unread: len(ids), Messages: len(ids), ids: ids}
+	err = checker.addThreads(&count, func() (int, error) { return countUnreadThreads(account.srv, account.user, labels) })
+	if err != nil {
+		return unreadCount{}, err
+	}
 
-			nextPageToken = resp.NextPageToken
+	if checker.context.Bool("categories") {
+		count.Categories, err = countCategories(account.srv, account.user, label)
+		if err != nil {
+			return unreadCount{}, err
 		}
+	}
 
-		fmt.Fprintf(c.App.Writer, "%d\n", total)
+	if checker.context.Bool("ages") {
+		count.Ages, err = findAges(account.fetcher, account.user, ids, time.Now())
+		if err != nil {
+			return unreadCount{}, err
+		}
+	}
+
+	return count, nil
+}
+
+// countedLabels are the labels of the messages that are counted under label, which are only the Primary tab's with --primary
+func countedLabels(c *cli.Context, label string) []string {
+	labels := []string{label}
+	if c.Bool("primary") || c.GlobalBool("primary") {
+		labels = append(labels, categoryPrimary)
+	}
+
+	return labels
+}
+
+// addThreads adds the number of unread conversations from countConversations to count when threads are counted
+func (checker *checker) addThreads(count *unreadCount, countConversations func() (int, error)) error {
+	if !checker.countsThreads() {
 		return nil
 	}
+
+	threads, err := countConversations()
+	if err != nil {
+		return err
+	}
+
+	count.Threads = &threads
+	if checker.context.String("count") == countThreads {
+		count.Unread = threads
+	}
+
+	return nil
+}
+
+// gmailAccounts returns the Gmail accounts that have been checked, whose messages can be described
+func (checker *checker) gmailAccounts() []gmailProvider {
+	accounts := []gmailProvider{}
+	if checker.account != nil {
+		accounts = append(accounts, *checker.account)
+	}
+
+	for _, account := range checker.folders {
+		if gmailAccount, ok := account.provider.(gmailProvider); ok {
+			accounts = append(accounts, gmailAccount)
+		}
+	}
+
+	return accounts
+}
+
+// findsNewMessages is whether the ids of unread messages are needed to tell which messages are new.
+// Otherwise only the server's unread totals are fetched, since listing every unread message can take many requests.
+func (checker *checker) findsNewMessages() bool {
+	return checker.context.Bool("watch") && (checker.notifier != nil || checker.webhooks != nil)
+}
+
+// countsThreads is whether unread conversations are counted
+func (checker *checker) countsThreads() bool {
+	mode := checker.context.String("count")
+	return mode == countThreads || mode == countBoth
+}
+
+// report writes the results of a check to all of the configured outputs.
+// It returns the check error.  Failures of the other outputs are reported to errWriter instead, so they never hide the count.
+func (checker *checker) report(out formatter, counts []unreadCount, err error) error {
+	c := checker.context
+	if textfileDir := c.String("textfileDir"); textfileDir != "" {
+		textfileErr := writeTextfile(textfileDir, counts, err)
+		if textfileErr != nil {
+			fmt.Fprintf(checker.errWriter, "%v\n", textfileErr)
+		}
+	}
+
+	if checker.mqtt != nil && err == nil {
+		publishErr := checker.mqtt.publish(counts)
+		if publishErr != nil {
+			fmt.Fprintf(checker.errWriter, "%v\n", publishErr)
+		}
+	}
+
+	// Status bars show an error block instead of going blank when a one-shot check fails
+	if err == nil || c.Bool("watch") || isStatusBar(c.String("format")) {
+		// The header is delayed until the first result so it does not precede the OAuth prompt
+		if !checker.started {
+			fmt.Fprint(c.App.Writer, out.header())
+			checker.started = true
+		}
+
+		fmt.Fprint(c.App.Writer, out.format(counts, err))
+	}
+
+	return err
+}
+
+// getService returns a gmail service along with the authorized client it uses.
+// The read only scope is always requested along with any extra scopes.
+func getService(c *cli.Context, cmdBuilder runner.Builder, scopes ...string) (*gmail.Service, *http.Client, error) {
+	tokenClient, err := newGmailClient(c, cmdBuilder, scopes...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not initialize token client: %v", err)
+	}
+
+	httpClient, err := tokenClient.GetHTTPClient(c.App.Writer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not get OAuth token: %v", err)
+	}
+
+	srv, _ := gmail.New(httpClient)
+	if BasePath != "" {
+		srv.BasePath = BasePath
+	}
+
+	return srv, httpClient, nil
+}
+
+// newGmailClient returns a Client for the app credentials from --client-id and --client-secret, --credentialJSON,
+// or --credentialFile, which is read from stdin when it is -
+func newGmailClient(c *cli.Context, cmdBuilder runner.Builder, scopes ...string) (*Client, error) {
+	tokenFile := globalString(c, "tokenFile")
+	if clientID := globalString(c, "client-id"); clientID != "" {
+		return NewClientFromID(clientID, globalString(c, "client-secret"), tokenFile, cmdBuilder, scopes...), nil
+	}
+
+	if credentials := globalString(c, "credentialJSON"); credentials != "" {
+		return NewClientFromJSON([]byte(credentials), tokenFile, cmdBuilder, scopes...)
+	}
+
+	if globalString(c, "credentialFile") == "-" {
+		credentials, err := ioutil.ReadAll(Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read app credentials from stdin: %v", err)
+		}
+
+		return NewClientFromJSON(credentials, tokenFile, cmdBuilder, scopes...)
+	}
+
+	return NewClient(globalString(c, "credentialFile"), tokenFile, cmdBuilder, scopes...)
+}
+
+// hasGmailCredentials is whether the Gmail app credentials are given in any of the ways newGmailClient accepts
+func hasGmailCredentials(c *cli.Context) bool {
+	return globalString(c, "credentialFile") != "" || globalString(c, "credentialJSON") != "" || globalString(c, "client-id") != ""
+}
+
+// countChange is a count that differs from the previous check
+type countChange struct {
+	previous unreadCount
+	current  unreadCount
+}
+
+// findChanges returns the counts that have changed since the previous check
+func findChanges(previous, current []unreadCount) []countChange {
+	changes := []countChange{}
+	for _, count := range current {
+		previousCount := findCount(previous, count.Account, count.Label)
+		if previousCount != nil && previousCount.Unread != count.Unread {
+			changes = append(changes, countChange{previous: *previousCount, current: count})
+		}
+	}
+
+	return changes
+}
+
+func findCount(counts []unreadCount, account, label string) *unreadCount {
+	for index := range counts {
+		if counts[index].Account == account && counts[index].Label == label {
+			return &counts[index]
+		}
+	}
+
+	return nil
+}
+
+// newMessageIDs returns the ids of the messages in current that were not unread in previous, newest first
+func newMessageIDs(previous, current unreadCount) []string {
+	previousIDs := make(map[string]bool, len(previous.ids))
+	for _, id := range previous.ids {
+		previousIDs[id] = true
+	}
+
+	ids := []string{}
+	for _, id := range current.ids {
+		if id != "" && !previousIDs[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// listUnread returns the ids of the unread messages under label, newest first.
+// If limit is positive at most limit ids are returned.
+func listUnread(srv *gmail.Service, user, label string, limit int) ([]string, error) {
+	return listUnreadLabels(srv, user, []string{label}, limit)
+}
+
+// listUnreadLabels returns the ids of the unread messages that have all of labels, newest first.
+// If limit is positive at most limit ids are returned.
+func listUnreadLabels(srv *gmail.Service, user string, labels []string, limit int) ([]string, error) {
+	ids := []string{}
+	call := srv.Users.Messages.List(user).LabelIds(labels...).Q(unreadQuery)
+	if limit > 0 {
+		call = call.MaxResults(int64(limit))
+	}
+
+	resp, err := call.Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check inbox. %v", err)
+	}
+
+	ids = appendMessageIDs(ids, resp.Messages)
+
+	nextPageToken := resp.NextPageToken
+
+	for nextPageToken != "" && (limit <= 0 || len(ids) < limit) {
+		resp, err = call.PageToken(nextPageToken).Do()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to check inbox. %v", err)
+		}
+
+		ids = appendMessageIDs(ids, resp.Messages)
+
+		nextPageToken = resp.NextPageToken
+	}
+
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	return ids, nil
+}
+
+// countUnreadThreads returns the number of conversations with all of labels that have unread messages, which is what the Gmail web interface shows
+func countUnreadThreads(srv *gmail.Service, user string, labels []string) (int, error) {
+	call := srv.Users.Threads.List(user).LabelIds(labels...).Q(unreadQuery)
+	resp, err := call.Do()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to check inbox threads. %v", err)
+	}
+
+	threads := len(resp.Threads)
+	for resp.NextPageToken != "" {
+		resp, err = call.PageToken(resp.NextPageToken).Do()
+		if err != nil {
+			return 0, fmt.Errorf("Unable to check inbox threads. %v", err)
+		}
+
+		threads += len(resp.Threads)
+	}
+
+	return threads, nil
+}
+
+func appendMessageIDs(ids []string, messages []*gmail.Message) []string {
+	for _, message := range messages {
+		ids = append(ids, message.Id)
+	}
+
+	return ids
+}
+
+// gmailConfigured is whether the Gmail account should be checked.  It is skipped when only other accounts are given.
+func gmailConfigured(c *cli.Context) bool {
+	return hasGmailCredentials(c) || globalString(c, "tokenFile") != "" || !otherAccountsConfigured(c)
+}
+
+// otherAccountsConfigured is whether an account other than the Gmail OAuth account is given
+func otherAccountsConfigured(c *cli.Context) bool {
+	for _, flag := range accountFlags {
+		if len(globalStringSlice(c, flag)) != 0 {
+			return true
+		}
+	}
+
+	return globalString(c, "outlookClientID") != "" || globalString(c, "serviceAccountFile") != ""
 }
 
 func checkFlags(c *cli.Context) error {
-	if c.String("credentialFile") == "" {
+	if !hasGmailCredentials(c) {
 		return cli.NewExitError("You must specify a credentialFile", 1)
 	}
 
-	if c.String("tokenFile") == "" {
+	if globalString(c, "tokenFile") == "" {
 		return cli.NewExitError("You must specify a tokenFile", 1)
 	}
 
 	return nil
 }
+
+// gmailUser is the Gmail mailbox to check, which is the authenticated user's unless --user names another mailbox it has been delegated access to
+func gmailUser(c *cli.Context) string {
+	if user := globalString(c, "user"); user != "" {
+		return user
+	}
+
+	return defaultUser
+}
+
+// gmailAddress looks up the email address of a Gmail mailbox
+func gmailAddress(srv *gmail.Service, user string) (string, error) {
+	profile, err := srv.Users.GetProfile(user).Do()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get the profile of %s. %v", user, err)
+	}
+
+	return profile.EmailAddress, nil
+}
+
+// globalString returns a flag from the current context or, for subcommands, from the global flags
+func globalString(c *cli.Context, name string) string {
+	if value := c.String(name); value != "" {
+		return value
+	}
+
+	return c.GlobalString(name)
+}
+
+// globalStringSlice returns a slice flag from the current context or, for subcommands, from the global flags
+func globalStringSlice(c *cli.Context, name string) []string {
+	if value := c.StringSlice(name); len(value) != 0 {
+		return value
+	}
+
+	return c.GlobalStringSlice(name)
+}
@@ -14,6 +14,8 @@ import (
 	"strings"
 	"testing"
 
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	gmail "google.golang.org/api/gmail/v1"
 
 	"github.com/guywithnose/runner"
@@ -195,6 +197,10 @@ func getMockGoogleAPI(t *testing.T) *httptest.Server {
 			return
 		}
 
+		if serveProfile(t, w, r) {
+			return
+		}
+
 		if r.URL.String() == "/me/messages?alt=json&labelIds=INBOX&q=label%3Aunread" {
 			resp := gmail.ListMessagesResponse{
 				Messages: []*gmail.Message{
@@ -242,6 +248,10 @@ func getMockGoogleAPIInboxFailure(t *testing.T) *httptest.Server {
 			return
 		}
 
+		if serveProfile(t, w, r) {
+			return
+		}
+
 		if r.URL.String() == "/me/messages?alt=json&labelIds=INBOX&q=label%3Aunread" {
 			w.WriteHeader(500)
 			return
@@ -270,3 +280,76 @@ func getMockGoogleAPITokenFailure(t *testing.T) *httptest.Server {
 		w.WriteHeader(500)
 	}))
 }
+
+func TestCmdCheckCredentialJSON(t *testing.T) {
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	output, err := runCredentialsCheck(t, ts.URL, func(set *flag.FlagSet) {
+		set.String("credentialJSON", string(getTestCredentials(ts.URL)), "doc")
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "\n4\n")
+}
+
+func TestCmdCheckCredentialStdin(t *testing.T) {
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.Stdin = bytes.NewReader(getTestCredentials(ts.URL))
+	defer func() { command.Stdin = os.Stdin }()
+	output, err := runCredentialsCheck(t, ts.URL, func(set *flag.FlagSet) {
+		set.String("credentialFile", "-", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "\n4\n")
+}
+
+func TestCmdCheckCredentialStdinInvalid(t *testing.T) {
+	command.Stdin = strings.NewReader("client_id=id")
+	defer func() { command.Stdin = os.Stdin }()
+	set := flag.NewFlagSet("test", 0)
+	set.String("credentialFile", "-", "doc")
+	set.String("tokenFile", filepath.Join(os.TempDir(), "testUnreadChecker", "tokenFile"), "doc")
+	app, _, _ := appWithTestWriters()
+	assert.EqualError(
+		t,
+		command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)),
+		"Could not initialize token client: Unable to parse app credentials: invalid character 'c' looking for beginning of value",
+	)
+}
+
+func TestCmdCheckClientID(t *testing.T) {
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.GoogleEndpoint = oauth2.Endpoint{AuthURL: ts.URL + "/auth", TokenURL: ts.URL + "/token"}
+	defer func() { command.GoogleEndpoint = google.Endpoint }()
+	var OAuthURL string
+	output, err := runCredentialsCheck(t, ts.URL, func(set *flag.FlagSet) {
+		set.String("client-id", "cliClient", "doc")
+		set.String("client-secret", "cliSecret", "doc")
+	})
+	assert.Nil(t, err)
+	lines := strings.Split(output, "\n")
+	_, err = fmt.Sscanf(lines[0], "Attempting to open %s in your browser", &OAuthURL)
+	assert.Nil(t, err)
+	parsed, err := url.Parse(OAuthURL)
+	assert.Nil(t, err)
+	assert.Equal(t, "/auth", parsed.Path)
+	assert.Equal(t, "cliClient", parsed.Query().Get("client_id"))
+	assert.Equal(t, "4", lines[1])
+}
+
+// runCredentialsCheck checks the mock API with a new token, using the app credentials given by setFlags
+func runCredentialsCheck(t *testing.T, mockAPIURL string, setFlags func(*flag.FlagSet)) (string, error) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	command.BasePath = mockAPIURL
+	set := flag.NewFlagSet("test", 0)
+	set.String("tokenFile", filepath.Join(testFolder, "tokenFile"), "doc")
+	setFlags(set)
+	app, writer, _ := appWithTestWriters()
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	err := command.CmdCheck(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	return writer.String(), err
+}
@@ -0,0 +1,374 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configFileNames are the names the config file is looked for under, in order
+var configFileNames = []string{"config.toml", "config.yaml", "config.yml"}
+
+// configSection maps global flag names to their values.  Values of slice flags may have several entries.
+type configSection map[string][]string
+
+// config is a parsed config file.  The settings at the top apply to every profile.
+type config struct {
+	path     string
+	settings configSection
+	profiles map[string]configSection
+}
+
+// LoadConfig fills in the global flags that were not given on the command line or in the environment from the config file,
+// using the settings of the selected profile over the settings at the top of the file.
+// The Gmail credential and token files default to files in the XDG config and state directories.
+func LoadConfig(c *cli.Context) error {
+	// config validate reports the problems in the file itself instead of failing here
+	if c.Args().First() == "config" && c.Args().Get(1) == "validate" {
+		return nil
+	}
+
+	cfg, err := findConfig(c)
+	if err != nil {
+		return err
+	}
+
+	profile := c.String("profile")
+	if cfg != nil {
+		if profile == "" && len(cfg.settings["profile"]) == 1 {
+			profile = cfg.settings["profile"][0]
+		}
+
+		var settings configSection
+		settings, err = cfg.merged(profile)
+		if err != nil {
+			return err
+		}
+
+		err = applyConfig(c, cfg, settings)
+		if err != nil {
+			return err
+		}
+
+		// Record the file and profile that were used for config show and config validate
+		_ = c.Set("configFile", cfg.path)
+		if profile != "" {
+			_ = c.Set("profile", profile)
+		}
+	} else if profile != "" {
+		return cli.NewExitError(fmt.Sprintf("Unable to use profile %s: no config file in %s", profile, configDir()), 1)
+	}
+
+	return applyDefaultPaths(c, profile)
+}
+
+// findConfig loads the config file, if there is one
+func findConfig(c *cli.Context) (*config, error) {
+	path := configPath(c)
+	if path == "" {
+		return nil, nil
+	}
+
+	return loadConfig(path)
+}
+
+// configPath is --configFile, or the first config file found in the config directory
+func configPath(c *cli.Context) string {
+	if path := c.GlobalString("configFile"); path != "" {
+		return path
+	}
+
+	for _, name := range configFileNames {
+		path := filepath.Join(configDir(), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// configDir is unreadChecker's directory in $XDG_CONFIG_HOME, which defaults to ~/.config
+func configDir() string {
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// stateDir is unreadChecker's directory in $XDG_STATE_HOME, which defaults to ~/.local/state
+func stateDir() string {
+	return xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+}
+
+func xdgDir(envVar, fallback string) string {
+	base := os.Getenv(envVar)
+	if !filepath.IsAbs(base) {
+		home, _ := os.UserHomeDir()
+		base = filepath.Join(home, fallback)
+	}
+
+	return filepath.Join(base, Name)
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Unable to read config file %s: %v", path, err), 1)
+	}
+
+	var tree map[string]interface{}
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		err = yaml.UnmarshalStrict(data, &tree)
+	} else {
+		_, err = toml.Decode(string(data), &tree)
+	}
+
+	cfg := &config{path: path, settings: configSection{}, profiles: map[string]configSection{}}
+	if err == nil {
+		err = cfg.fill(tree)
+	}
+
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Unable to parse config file %s: %v", path, err), 1)
+	}
+
+	return cfg, nil
+}
+
+// fill maps the decoded file into the settings at the top and a section for each entry of profiles
+func (cfg *config) fill(tree map[string]interface{}) error {
+	for key, value := range tree {
+		if key != "profiles" {
+			err := cfg.settings.set(key, value)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		profiles, ok := configMapping(value)
+		if !ok {
+			return errors.New("profiles must be a mapping of profile names to settings")
+		}
+
+		for name, settings := range profiles {
+			mapping, ok := configMapping(settings)
+			if !ok {
+				return fmt.Errorf("profile %s must be a mapping of settings", name)
+			}
+
+			section := cfg.profile(name)
+			for key, value := range mapping {
+				err := section.set(key, value)
+				if err != nil {
+					return fmt.Errorf("profile %s: %v", name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// configMapping converts the mappings of both decoders to a map with string keys
+func configMapping(value interface{}) (map[string]interface{}, bool) {
+	switch mapping := value.(type) {
+	case map[string]interface{}:
+		return mapping, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(mapping))
+		for key, item := range mapping {
+			converted[fmt.Sprint(key)] = item
+		}
+
+		return converted, true
+	}
+
+	return nil, false
+}
+
+func (cfg *config) profile(name string) configSection {
+	if cfg.profiles[name] == nil {
+		cfg.profiles[name] = configSection{}
+	}
+
+	return cfg.profiles[name]
+}
+
+// merged returns the settings at the top of the file overridden by the settings of profile
+func (cfg *config) merged(profile string) (configSection, error) {
+	settings := configSection{}
+	for key, values := range cfg.settings {
+		if key != "profile" {
+			settings[key] = values
+		}
+	}
+
+	if profile == "" {
+		return settings, nil
+	}
+
+	profileSettings, ok := cfg.profiles[profile]
+	if !ok {
+		return nil, cli.NewExitError(fmt.Sprintf("Unknown profile %s in %s, the profiles are: %s", profile, cfg.path, strings.Join(cfg.profileNames(), ", ")), 1)
+	}
+
+	for key, values := range profileSettings {
+		if key == "profile" {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid setting in profile %s of %s: profile can only be set at the top of the file", profile, cfg.path), 1)
+		}
+
+		settings[key] = values
+	}
+
+	return settings, nil
+}
+
+func (cfg *config) profileNames() []string {
+	names := make([]string, 0, len(cfg.profiles))
+	for name := range cfg.profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// set stores value, which is a scalar or a list of scalars, under key.  An empty YAML value is an empty list.
+func (section configSection) set(key string, value interface{}) error {
+	items, isList := value.([]interface{})
+	if !isList && value != nil {
+		items = []interface{}{value}
+	}
+
+	values := []string{}
+	for _, item := range items {
+		scalar, ok := configScalar(item)
+		if !ok {
+			return fmt.Errorf("%s must be a value or a list of values", key)
+		}
+
+		values = append(values, scalar)
+	}
+
+	section[key] = values
+	return nil
+}
+
+// configScalar formats a decoded string, boolean or number the way it would be given on the command line
+func configScalar(value interface{}) (string, bool) {
+	switch scalar := value.(type) {
+	case string:
+		return scalar, true
+	case bool:
+		return strconv.FormatBool(scalar), true
+	case int:
+		return strconv.Itoa(scalar), true
+	case int64:
+		return strconv.FormatInt(scalar, 10), true
+	case float64:
+		return strconv.FormatFloat(scalar, 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+// applyConfig sets each global flag in settings that was not given on the command line or in its environment variable
+func applyConfig(c *cli.Context, cfg *config, settings configSection) error {
+	flags := map[string]cli.Flag{}
+	for _, flag := range c.App.Flags {
+		flags[flag.GetName()] = flag
+	}
+
+	for _, key := range sortedKeys(settings) {
+		flag, ok := flags[key]
+		if !ok || key == "configFile" {
+			return cli.NewExitError(fmt.Sprintf("Unknown setting %s in %s", key, cfg.path), 1)
+		}
+
+		if c.IsSet(key) {
+			continue
+		}
+
+		values := settings[key]
+		if _, isSlice := flag.(cli.StringSliceFlag); !isSlice && len(values) != 1 {
+			return cli.NewExitError(fmt.Sprintf("Invalid setting %s in %s: expected a single value", key, cfg.path), 1)
+		}
+
+		for _, value := range values {
+			if isConfigPath(key) {
+				value = resolveConfigPath(cfg.path, value)
+			}
+
+			err := c.Set(key, value)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Invalid setting %s in %s: %v", key, cfg.path, err), 1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isConfigPath is whether a setting is a file or directory, which may be relative to the config file
+func isConfigPath(key string) bool {
+	return strings.HasSuffix(key, "File") || strings.HasSuffix(key, "Dir") || key == "webhookLog" || key == "maildir" || key == "mbox"
+}
+
+// resolveConfigPath expands ~ and makes relative paths relative to the directory of the config file
+func resolveConfigPath(configPath, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[1:])
+	}
+
+	if path == "" || path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+// applyDefaultPaths uses credentials.json in the config directory when no credential file or other account is given,
+// and keeps the tokens in the state directory, with a directory for each profile, when no token file is given.
+func applyDefaultPaths(c *cli.Context, profile string) error {
+	if !hasGmailCredentials(c) && !otherAccountsConfigured(c) {
+		path := filepath.Join(configDir(), "credentials.json")
+		if _, err := os.Stat(path); err == nil {
+			_ = c.Set("credentialFile", path)
+		}
+	}
+
+	defaults := map[string]string{}
+	if hasGmailCredentials(c) {
+		defaults["tokenFile"] = "token.json"
+	}
+
+	if c.String("outlookClientID") != "" {
+		defaults["outlookTokenFile"] = "outlook-token.json"
+	}
+
+	dir := filepath.Join(stateDir(), profile)
+	for flag, name := range defaults {
+		if c.String(flag) != "" {
+			continue
+		}
+
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to create %s: %v", dir, err), 1)
+		}
+
+		_ = c.Set(flag, filepath.Join(dir, name))
+	}
+
+	return nil
+}
@@ -0,0 +1,391 @@
+package command
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/oauth2/google"
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// bareProfileName matches the profile names that do not need to be quoted in a TOML table name
+var bareProfileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CmdConfigInit asks for the downloaded credential file, copies it into the config directory,
+// logs in and writes a profile that uses it to the config file
+func CmdConfigInit(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 {
+			return cli.NewExitError("Usage: \"unreadChecker config init\"", 1)
+		}
+
+		path := c.GlobalString("configFile")
+		if path == "" {
+			path = filepath.Join(configDir(), configFileNames[0])
+		}
+
+		var cfg *config
+		if _, err := os.Stat(path); err == nil {
+			if !strings.HasSuffix(path, ".toml") {
+				return cli.NewExitError(fmt.Sprintf("config init can only add profiles to a TOML config file, add the profile to %s by hand", path), 1)
+			}
+
+			cfg, err = loadConfig(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		reader := bufio.NewReader(Stdin)
+		fmt.Fprint(c.App.Writer, "Path to the OAuth credential file you downloaded: ")
+		source := resolveConfigPath("", readAnswer(reader))
+		credentials, err := ioutil.ReadFile(source)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to read credential file %s: %v", source, err), 1)
+		}
+
+		_, err = google.ConfigFromJSON(credentials, gmail.GmailReadonlyScope)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid credential file %s: %v", source, err), 1)
+		}
+
+		fmt.Fprint(c.App.Writer, "Profile name, or nothing for the default settings: ")
+		profile := readAnswer(reader)
+		credentialName := "credentials.json"
+		if profile != "" {
+			credentialName = fmt.Sprintf("credentials-%s.json", profile)
+		}
+
+		if cfg != nil {
+			if profile == "" {
+				return cli.NewExitError(fmt.Sprintf("%s already exists, give the new settings a profile name", path), 1)
+			}
+
+			if _, ok := cfg.profiles[profile]; ok {
+				return cli.NewExitError(fmt.Sprintf("Profile %s already exists in %s", profile, path), 1)
+			}
+		}
+
+		dir := filepath.Dir(path)
+		tokenDir := filepath.Join(stateDir(), profile)
+		for _, createDir := range []string{dir, tokenDir} {
+			err = os.MkdirAll(createDir, 0700)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to create %s: %v", createDir, err), 1)
+			}
+		}
+
+		credentialFile := filepath.Join(dir, credentialName)
+		err = ioutil.WriteFile(credentialFile, credentials, 0600)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to copy credential file: %v", err), 1)
+		}
+
+		tokenFile := filepath.Join(tokenDir, "token.json")
+		tokenClient, err := NewClient(credentialFile, tokenFile, cmdBuilder)
+		if err != nil {
+			return fmt.Errorf("Could not initialize token client: %v", err)
+		}
+
+		_, err = tokenClient.GetHTTPClient(c.App.Writer)
+		if err != nil {
+			return fmt.Errorf("Could not get OAuth token: %v", err)
+		}
+
+		return writeProfile(c, path, profile, credentialName, tokenFile)
+	}
+}
+
+func readAnswer(reader *bufio.Reader) string {
+	answer, _ := reader.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// writeProfile appends the settings for profile to the config file, creating it if needed
+func writeProfile(c *cli.Context, path, profile, credentialFile, tokenFile string) error {
+	settings := ""
+	if profile != "" {
+		name := profile
+		if !bareProfileName.MatchString(name) {
+			name = strconv.Quote(name)
+		}
+
+		settings = fmt.Sprintf("\n[profiles.%s]\n", name)
+	}
+
+	settings += fmt.Sprintf("credentialFile = %s\ntokenFile = %s\n", strconv.Quote(credentialFile), strconv.Quote(tokenFile))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to write config file %s: %v", path, err), 1)
+	}
+
+	_, err = file.WriteString(settings)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to write config file %s: %v", path, err), 1)
+	}
+
+	if profile == "" {
+		fmt.Fprintf(c.App.Writer, "Wrote %s\n", path)
+	} else {
+		fmt.Fprintf(c.App.Writer, "Wrote profile %s to %s, use it with --profile %s\n", profile, path, profile)
+	}
+
+	return nil
+}
+
+// CmdConfigValidate checks the settings at the top of the config file and in each profile
+func CmdConfigValidate(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("Usage: \"unreadChecker config validate\"", 1)
+	}
+
+	path := configPath(c)
+	if path == "" {
+		return cli.NewExitError(fmt.Sprintf("There is no config file in %s", configDir()), 1)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	seen := map[string]bool{}
+	for _, profile := range append([]string{""}, cfg.profileNames()...) {
+		prefix := ""
+		if profile != "" {
+			prefix = fmt.Sprintf("profile %s: ", profile)
+		}
+
+		for _, problem := range validateProfile(c.App, cfg, profile) {
+			if !seen[problem] {
+				seen[problem] = true
+				problems = append(problems, prefix+problem)
+			}
+		}
+	}
+
+	if info, err := os.Stat(path); err == nil && cfg.hasSecrets() && info.Mode().Perm()&0077 != 0 {
+		problems = append(problems, fmt.Sprintf("%s holds secrets and can be read by other users, run chmod 600 %s", path, path))
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(c.App.Writer, problem)
+	}
+
+	if len(problems) != 0 {
+		return cli.NewExitError(fmt.Sprintf("%s is not valid", path), 1)
+	}
+
+	fmt.Fprintf(c.App.Writer, "%s is valid\n", path)
+	return nil
+}
+
+// validateProfile applies the settings of profile to a fresh set of flags and checks the accounts and files they refer to
+func validateProfile(app *cli.App, cfg *config, profile string) []string {
+	settings, err := cfg.merged(profile)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	set := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	for _, appFlag := range app.Flags {
+		appFlag.Apply(set)
+	}
+
+	c := cli.NewContext(app, set, nil)
+	err = applyConfig(c, cfg, settings)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	imapAccounts, err := newIMAPProviders(c)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for _, account := range imapAccounts {
+		problems = append(problems, checkLabel(account.name(), account.mailbox)...)
+	}
+
+	jmapAccounts, err := newJMAPProviders(c)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for _, account := range jmapAccounts {
+		problems = append(problems, checkLabel(account.provider.name(), account.folder)...)
+	}
+
+	for _, key := range sortedKeys(settings) {
+		if !isConfigPath(key) {
+			continue
+		}
+
+		for _, value := range settings[key] {
+			problems = append(problems, checkConfigFile(key, resolveConfigPath(cfg.path, value))...)
+		}
+	}
+
+	return problems
+}
+
+// checkLabel reports mailbox names that servers will not accept
+func checkLabel(account, label string) []string {
+	if label == "" || strings.Contains(label, "//") || strings.IndexFunc(label, unicode.IsControl) != -1 {
+		return []string{fmt.Sprintf("%s has an invalid mailbox name %q", account, label)}
+	}
+
+	return nil
+}
+
+// checkConfigFile reports files that are missing or that hold credentials other users can read.
+// Token files do not need to exist since they are created when logging in.
+func checkConfigFile(key, path string) []string {
+	if path == "-" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if isTokenFile(key) {
+			return nil
+		}
+
+		return []string{fmt.Sprintf("%s %s does not exist", key, path)}
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("Unable to check %s %s: %v", key, path, err)}
+	}
+
+	if (key == "credentialFile" || key == "serviceAccountFile" || isTokenFile(key)) && info.Mode().Perm()&0077 != 0 {
+		return []string{fmt.Sprintf("%s %s can be read by other users, run chmod 600 %s", key, path, path)}
+	}
+
+	return nil
+}
+
+func isTokenFile(key string) bool {
+	return key == "tokenFile" || key == "outlookTokenFile"
+}
+
+// isSecretSetting is whether a setting is a password or secret that should not be shown
+func isSecretSetting(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "secret") || strings.Contains(key, "password") || key == "credentialjson"
+}
+
+// hasSecrets is whether the config file holds a password or secret, including one in an account URL
+func (cfg *config) hasSecrets() bool {
+	for _, section := range append([]configSection{cfg.settings}, cfg.profileSections()...) {
+		for key, values := range section {
+			if isSecretSetting(key) {
+				return true
+			}
+
+			for _, value := range values {
+				if redactURL(value) != value {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (cfg *config) profileSections() []configSection {
+	sections := []configSection{}
+	for _, name := range cfg.profileNames() {
+		sections = append(sections, cfg.profiles[name])
+	}
+
+	return sections
+}
+
+// CmdConfigShow prints the settings in effect, after the config file, environment variables and flags are merged,
+// in the config file format with passwords and secrets redacted
+func CmdConfigShow(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("Usage: \"unreadChecker config show\"", 1)
+	}
+
+	switch path, profile := c.GlobalString("configFile"), c.GlobalString("profile"); {
+	case path == "":
+		fmt.Fprintln(c.App.Writer, "# There is no config file, these are the flags, environment variables and defaults")
+	case profile == "":
+		fmt.Fprintf(c.App.Writer, "# Settings from %s\n", path)
+	default:
+		fmt.Fprintf(c.App.Writer, "# Settings from %s with profile %s\n", path, profile)
+	}
+
+	for _, appFlag := range c.App.Flags {
+		name := appFlag.GetName()
+		if name == "configFile" || name == "profile" || name == cli.HelpFlag.GetName() || name == cli.VersionFlag.GetName() {
+			continue
+		}
+
+		switch appFlag.(type) {
+		case cli.StringSliceFlag:
+			values := []string{}
+			for _, value := range c.GlobalStringSlice(name) {
+				values = append(values, strconv.Quote(redactSetting(name, value)))
+			}
+
+			fmt.Fprintf(c.App.Writer, "%s = [%s]\n", name, strings.Join(values, ", "))
+		case cli.BoolFlag, cli.IntFlag:
+			fmt.Fprintf(c.App.Writer, "%s = %s\n", name, flagValue(c, name))
+		default:
+			fmt.Fprintf(c.App.Writer, "%s = %s\n", name, strconv.Quote(redactSetting(name, flagValue(c, name))))
+		}
+	}
+
+	return nil
+}
+
+// flagValue is the value of a global flag as it would be written on the command line
+func flagValue(c *cli.Context, name string) string {
+	value, ok := c.GlobalGeneric(name).(flag.Value)
+	if !ok {
+		return ""
+	}
+
+	return value.String()
+}
+
+func redactSetting(name, value string) string {
+	if isSecretSetting(name) && value != "" {
+		return "xxxxx"
+	}
+
+	return redactURL(value)
+}
+
+func sortedKeys(section configSection) []string {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
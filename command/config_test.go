@@ -0,0 +1,279 @@
+package command_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+const testTOMLConfig = `# unreadChecker settings
+credentialFile = "credentials/client.json"
+format = "json"
+profile = "work"
+
+[profiles.work]
+tokenFile = "work-token.json"
+imap = [
+  "imaps://alice@mail.example.com?passwordEnv=IMAP_PASSWORD", # the team inbox
+  'imaps://bob@mail.example.com',
+]
+watch = true
+
+[profiles."home"]
+tokenFile = "~/home-token.json"
+interval = "5m"
+warnColor = "#FFA500"
+`
+
+const testYAMLConfig = `# unreadChecker settings
+credentialFile: credentials/client.json
+format: json
+profile: work
+
+profiles:
+  work:
+    tokenFile: work-token.json
+    imap:
+      - imaps://alice@mail.example.com?passwordEnv=IMAP_PASSWORD # the team inbox
+      - 'imaps://bob@mail.example.com'
+    watch: true
+  home:
+    tokenFile: "~/home-token.json"
+    interval: 5m
+    warnColor: "#FFA500"
+`
+
+func TestLoadConfigTOML(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.toml", testTOMLConfig)
+	c, err := runLoadConfig(t)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dirs.config, "credentials", "client.json"), c.String("credentialFile"))
+	assert.Equal(t, filepath.Join(dirs.config, "work-token.json"), c.String("tokenFile"))
+	assert.Equal(t, "json", c.String("format"))
+	assert.Equal(t, []string{"imaps://alice@mail.example.com?passwordEnv=IMAP_PASSWORD", "imaps://bob@mail.example.com"}, c.StringSlice("imap"))
+	assert.True(t, c.Bool("watch"))
+	assert.Equal(t, time.Minute, c.Duration("interval"))
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.yaml", testYAMLConfig)
+	c, err := runLoadConfig(t, "--profile", "home")
+	assert.Nil(t, err)
+	home, _ := os.UserHomeDir()
+	assert.Equal(t, filepath.Join(dirs.config, "credentials", "client.json"), c.String("credentialFile"))
+	assert.Equal(t, filepath.Join(home, "home-token.json"), c.String("tokenFile"))
+	assert.Equal(t, []string{}, c.StringSlice("imap"))
+	assert.False(t, c.Bool("watch"))
+	assert.Equal(t, 5*time.Minute, c.Duration("interval"))
+	assert.Equal(t, "#FFA500", c.String("warnColor"))
+}
+
+func TestLoadConfigYAMLLists(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.yml", testYAMLConfig)
+	c, err := runLoadConfig(t)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"imaps://alice@mail.example.com?passwordEnv=IMAP_PASSWORD", "imaps://bob@mail.example.com"}, c.StringSlice("imap"))
+	assert.True(t, c.Bool("watch"))
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.toml", testTOMLConfig)
+	assert.Nil(t, os.Setenv("GMAIL_OAUTH_CREDENTIAL_FILE", "/env/client.json"))
+	defer func() { assert.Nil(t, os.Unsetenv("GMAIL_OAUTH_CREDENTIAL_FILE")) }()
+	c, err := runLoadConfig(t, "--format", "plain", "--imap", "imaps://carol@mail.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "/env/client.json", c.String("credentialFile"))
+	assert.Equal(t, "plain", c.String("format"))
+	assert.Equal(t, []string{"imaps://carol@mail.example.com"}, c.StringSlice("imap"))
+	assert.Equal(t, filepath.Join(dirs.config, "work-token.json"), c.String("tokenFile"))
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.toml", `format = "plain"`)
+	other := filepath.Join(dirs.config, "other.toml")
+	assert.Nil(t, ioutil.WriteFile(other, []byte(`format = "i3bar"`), 0600))
+	c, err := runLoadConfig(t, "--configFile", other)
+	assert.Nil(t, err)
+	assert.Equal(t, "i3bar", c.String("format"))
+}
+
+func TestLoadConfigDefaultPaths(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "credentials.json", "{}")
+	c, err := runLoadConfig(t)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dirs.config, "credentials.json"), c.String("credentialFile"))
+	assert.Equal(t, filepath.Join(dirs.state, "unreadChecker", "token.json"), c.String("tokenFile"))
+}
+
+func TestLoadConfigDefaultPathsProfile(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.toml", "[profiles.work]\ncredentialFile = \"credentials.json\"\noutlookClientID = \"outlookClient\"\n")
+	dirs.writeConfig(t, "credentials.json", "{}")
+	c, err := runLoadConfig(t, "--profile", "work")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dirs.state, "unreadChecker", "work", "token.json"), c.String("tokenFile"))
+	assert.Equal(t, filepath.Join(dirs.state, "unreadChecker", "work", "outlook-token.json"), c.String("outlookTokenFile"))
+	info, err := os.Stat(filepath.Join(dirs.state, "unreadChecker", "work"))
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestLoadConfigNoGmail(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "credentials.json", "{}")
+	c, err := runLoadConfig(t, "--imap", "imaps://carol@mail.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "", c.String("credentialFile"))
+	assert.Equal(t, "", c.String("tokenFile"))
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	configFile := filepath.Join(dirs.config, "config.toml")
+	tests := map[string]string{
+		"[accounts]\n":                            "Unable to parse config file " + configFile + ": accounts must be a value or a list of values",
+		"format = \"json\"\nformat\n":             "Unable to parse config file " + configFile + ": Near line 2 (last key parsed ''): expected '.' or '=', but got '\\n' instead",
+		"format = \"json\"\nformat = \"plain\"\n": "Unable to parse config file " + configFile + ": Near line 2 (last key parsed 'format'): Key 'format' has already been defined.",
+		"format = \"json\n":                       "Unable to parse config file " + configFile + ": Near line 1 (last key parsed 'format'): strings cannot contain newlines",
+		"colour = \"red\"\n":                      "Unknown setting colour in " + configFile,
+		"format = [\"json\", \"plain\"]\n":        "Invalid setting format in " + configFile + ": expected a single value",
+		"watch = \"sometimes\"\n":                 "Invalid setting watch in " + configFile + `: parse error`,
+		"profile = \"play\"\n[profiles.work]\n":   "Unknown profile play in " + configFile + ", the profiles are: work",
+	}
+	for contents, expected := range tests {
+		dirs.writeConfig(t, "config.toml", contents)
+		_, err := runLoadConfig(t)
+		assert.EqualError(t, err, expected, contents)
+	}
+}
+
+func TestLoadConfigYAMLErrors(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	configFile := filepath.Join(dirs.config, "config.yaml")
+	tests := map[string]string{
+		"- json\n":                               "Unable to parse config file " + configFile + ": yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]interface {}",
+		"format: json\nformat: plain\n":          "Unable to parse config file " + configFile + ": yaml: unmarshal errors:\n  line 2: key \"format\" already set in map",
+		"profiles: work\n":                       "Unable to parse config file " + configFile + ": profiles must be a mapping of profile names to settings",
+		"profiles:\n  work: json\n":              "Unable to parse config file " + configFile + ": profile work must be a mapping of settings",
+		"profiles:\n  work:\n    imap: {a: b}\n": "Unable to parse config file " + configFile + ": profile work: imap must be a value or a list of values",
+		"format: json\n  watch: true\n":          "Unable to parse config file " + configFile + ": yaml: line 2: mapping values are not allowed in this context",
+		"profiles:\n  work:\n    profile: x\n":   "Invalid setting in profile work of " + configFile + ": profile can only be set at the top of the file",
+	}
+	for contents, expected := range tests {
+		dirs.writeConfig(t, "config.yaml", contents)
+		_, err := runLoadConfig(t, "--profile", "work")
+		assert.EqualError(t, err, expected, contents)
+	}
+}
+
+func TestLoadConfigTOMLTables(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.toml", "profiles.home.format = \"plain\"\n\n[profiles]\nwork = {watch = true}\n")
+	c, err := runLoadConfig(t, "--profile", "work")
+	assert.Nil(t, err)
+	assert.True(t, c.Bool("watch"))
+	c, err = runLoadConfig(t, "--profile", "home")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", c.String("format"))
+}
+
+func TestLoadConfigYAMLStyles(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	dirs.writeConfig(t, "config.yaml", "---\nimap:\n- imaps://alice@mail.example.com\n- imaps://bob@mail.example.com\nwarnThreshold: 5\nprofiles:\n  work: {watch: true}\n")
+	c, err := runLoadConfig(t, "--profile", "work")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"imaps://alice@mail.example.com", "imaps://bob@mail.example.com"}, c.StringSlice("imap"))
+	assert.Equal(t, 5, c.Int("warnThreshold"))
+	assert.True(t, c.Bool("watch"))
+}
+
+func TestLoadConfigProfileWithoutConfig(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	_, err := runLoadConfig(t, "--profile", "work")
+	assert.EqualError(t, err, "Unable to use profile work: no config file in "+dirs.config)
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	dirs := setXDGDirs(t)
+	defer dirs.remove(t)
+	missing := filepath.Join(dirs.config, "missing.toml")
+	_, err := runLoadConfig(t, "--configFile", missing)
+	assert.EqualError(t, err, "Unable to read config file "+missing+": open "+missing+": no such file or directory")
+}
+
+// xdgDirs are temporary XDG config and state directories
+type xdgDirs struct {
+	base   string
+	config string
+	state  string
+}
+
+func setXDGDirs(t *testing.T) xdgDirs {
+	base, err := ioutil.TempDir("", "testUnreadChecker")
+	assert.Nil(t, err)
+	dirs := xdgDirs{base: base, config: filepath.Join(base, "config", "unreadChecker"), state: filepath.Join(base, "state")}
+	assert.Nil(t, os.MkdirAll(dirs.config, 0700))
+	assert.Nil(t, os.Setenv("XDG_CONFIG_HOME", filepath.Join(base, "config")))
+	assert.Nil(t, os.Setenv("XDG_STATE_HOME", dirs.state))
+	return dirs
+}
+
+func (dirs xdgDirs) writeConfig(t *testing.T, name, contents string) {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dirs.config, name), []byte(contents), 0600))
+}
+
+func (dirs xdgDirs) remove(t *testing.T) {
+	assert.Nil(t, os.Unsetenv("XDG_CONFIG_HOME"))
+	assert.Nil(t, os.Unsetenv("XDG_STATE_HOME"))
+	removeFile(t, dirs.base)
+}
+
+// configFlags are the global flags the config tests use
+var configFlags = []cli.Flag{
+	cli.StringFlag{Name: "configFile", EnvVar: "UNREADCHECKER_CONFIG"},
+	cli.StringFlag{Name: "profile", EnvVar: "UNREADCHECKER_PROFILE"},
+	cli.StringFlag{Name: "credentialFile", EnvVar: "GMAIL_OAUTH_CREDENTIAL_FILE"},
+	cli.StringFlag{Name: "tokenFile"},
+	cli.StringSliceFlag{Name: "imap"},
+	cli.StringSliceFlag{Name: "maildir"},
+	cli.StringFlag{Name: "outlookClientID"},
+	cli.StringFlag{Name: "outlookTokenFile"},
+	cli.StringFlag{Name: "format", Value: "plain"},
+	cli.BoolFlag{Name: "watch"},
+	cli.DurationFlag{Name: "interval", Value: time.Minute},
+	cli.IntFlag{Name: "warnThreshold", Value: 1},
+	cli.StringFlag{Name: "warnColor", Value: "#FFFF00"},
+	cli.StringFlag{Name: "webhookSecret"},
+}
+
+// runLoadConfig loads the config into a context with the global flags set from args
+func runLoadConfig(t *testing.T, args ...string) (*cli.Context, error) {
+	app, _, _ := appWithTestWriters()
+	c := newConfigContext(t, app, args...)
+	return c, command.LoadConfig(c)
+}
@@ -0,0 +1,122 @@
+package command_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckCountThreads(t *testing.T) {
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "threads", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n", output)
+}
+
+func TestCmdCheckCountBoth(t *testing.T) {
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "both", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4 (3 threads)\n", output)
+}
+
+func TestCmdCheckCountBothJSON(t *testing.T) {
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "both", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":4,"counts":[{"account":"me@example.com","label":"INBOX","unread":4,"messages":4,"threads":3}]}`+"\n", output)
+}
+
+func TestCmdCheckCountThreadsJSON(t *testing.T) {
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "threads", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":3,"counts":[{"account":"me@example.com","label":"INBOX","unread":3,"messages":4,"threads":3}]}`+"\n", output)
+}
+
+func TestCmdCheckCountMessagesJSON(t *testing.T) {
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":4,"counts":[{"account":"me@example.com","label":"INBOX","unread":4,"messages":4}]}`+"\n", output)
+}
+
+func TestCmdCheckCountThreadsTextfile(t *testing.T) {
+	textfileDir := filepath.Join(os.TempDir(), "testUnreadCheckerTextfile")
+	assert.Nil(t, os.MkdirAll(textfileDir, 0777))
+	defer removeFile(t, textfileDir)
+	_, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "threads", "doc")
+		set.String("textfileDir", textfileDir, "doc")
+	})
+	assert.Nil(t, err)
+	lines := readTextfile(t, textfileDir)
+	assert.Equal(
+		t,
+		[]string{
+			"# HELP unreadchecker_unread_messages Number of unread messages.",
+			"# TYPE unreadchecker_unread_messages gauge",
+			`unreadchecker_unread_messages{account="me@example.com",label="INBOX"} 4`,
+			"# HELP unreadchecker_unread_threads Number of conversations with unread messages.",
+			"# TYPE unreadchecker_unread_threads gauge",
+			`unreadchecker_unread_threads{account="me@example.com",label="INBOX"} 3`,
+		},
+		lines[:6],
+	)
+}
+
+func TestCmdCheckCountBothWithIMAP(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	textfileDir, err := ioutil.TempDir("", "testUnreadCheckerTextfile")
+	assert.Nil(t, err)
+	defer removeFile(t, textfileDir)
+	output, err := runCountCheck(t, func(set *flag.FlagSet) {
+		set.String("count", "both", "doc")
+		set.String("textfileDir", textfileDir, "doc")
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "7 (3 threads + 3 unthreaded messages)\n", output)
+	lines := readTextfile(t, textfileDir)
+	assert.Contains(t, lines, `unreadchecker_unread_threads{account="me@example.com",label="INBOX"} 3`)
+	imapMessages := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, `unreadchecker_unread_messages{account="alice`) {
+			imapMessages++
+		}
+		if strings.HasPrefix(line, "unreadchecker_unread_threads") {
+			assert.NotContains(t, line, "alice")
+		}
+	}
+	assert.Equal(t, 1, imapMessages)
+}
+
+func TestCmdCheckInvalidCount(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("count", "labels", "doc")
+	assert.EqualError(t, command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)), "Invalid count: labels")
+}
+
+// runCountCheck runs CmdCheck against a mock API with four unread messages in three threads
+func runCountCheck(t *testing.T, setFlags func(*flag.FlagSet)) (string, error) {
+	return runGmailCheck(t, &mockGmail{t: t, checks: [][]string{{"d", "c", "b", "a"}}, threads: map[string]string{"c": "a"}}, setFlags)
+}
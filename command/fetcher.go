@@ -0,0 +1,262 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// FetchBackoff is how long to wait before retrying rate limited message fetches.  It doubles after each retry.
+var FetchBackoff = time.Second
+
+const (
+	// fetchBatchSize is the number of messages requested in each batch.  Gmail allows 100 but throttles large batches.
+	fetchBatchSize = 50
+	// fetchConcurrency is the number of concurrent requests made when batching is unavailable
+	fetchConcurrency = 10
+	// fetchRetries is the number of times rate limited requests are retried
+	fetchRetries = 3
+)
+
+// messageFetcher fetches the metadata of many messages, using Gmail's batch endpoint when it is available
+type messageFetcher struct {
+	srv           *gmail.Service
+	httpClient    *http.Client
+	headers       []string
+	batchUnusable bool
+}
+
+// fetchResult is the result of fetching a single message.  A failure only affects its own message.
+type fetchResult struct {
+	message *gmail.Message
+	err     error
+}
+
+func newMessageFetcher(srv *gmail.Service, httpClient *http.Client, headers ...string) *messageFetcher {
+	return &messageFetcher{srv: srv, httpClient: httpClient, headers: headers}
+}
+
+// fetch returns the metadata of each message in the same order as ids
+func (fetcher *messageFetcher) fetch(user string, ids []string) []fetchResult {
+	results := make([]fetchResult, len(ids))
+	pending := make([]int, len(ids))
+	for index := range ids {
+		pending[index] = index
+	}
+
+	backoff := FetchBackoff
+	for attempt := 0; len(pending) != 0; attempt++ {
+		if attempt != 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		for start := 0; start < len(pending); start += fetchBatchSize {
+			end := start + fetchBatchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+
+			fetcher.fetchChunk(user, ids, pending[start:end], results)
+		}
+
+		retry := []int{}
+		for _, index := range pending {
+			if attempt < fetchRetries && isRateLimited(results[index].err) {
+				retry = append(retry, index)
+			}
+		}
+
+		pending = retry
+	}
+
+	return results
+}
+
+// fetchRefs fetches messages in the Gmail accounts with each account's fetcher, returning results in the same order as refs
+func fetchRefs(accounts []gmailProvider, refs []messageRef) []fetchResult {
+	results := make([]fetchResult, len(refs))
+	for _, account := range accounts {
+		indexes := []int{}
+		ids := []string{}
+		for index, ref := range refs {
+			if ref.Account == account.name() {
+				indexes = append(indexes, index)
+				ids = append(ids, ref.ID)
+			}
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		for position, result := range account.fetcher.fetch(account.user, ids) {
+			results[indexes[position]] = result
+		}
+	}
+
+	return results
+}
+
+func (fetcher *messageFetcher) fetchChunk(user string, ids []string, chunk []int, results []fetchResult) {
+	if !fetcher.batchUnusable {
+		err := fetcher.fetchBatch(user, ids, chunk, results)
+		if err == nil {
+			return
+		}
+
+		// Fall back to individual requests for this and every later chunk
+		fetcher.batchUnusable = true
+	}
+
+	fetcher.fetchConcurrently(user, ids, chunk, results)
+}
+
+// fetchConcurrently fetches each message in its own request using a bounded number of goroutines
+func (fetcher *messageFetcher) fetchConcurrently(user string, ids []string, chunk []int, results []fetchResult) {
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for worker := 0; worker < fetchConcurrency && worker < len(chunk); worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				message, err := fetcher.srv.Users.Messages.Get(user, ids[index]).Format("metadata").MetadataHeaders(fetcher.headers...).Do()
+				results[index] = fetchResult{message: message, err: err}
+			}
+		}()
+	}
+
+	for _, index := range chunk {
+		indexes <- index
+	}
+
+	close(indexes)
+	workers.Wait()
+}
+
+// fetchBatch fetches a chunk of messages with a single multipart batch request.
+// An error is returned only if the batch request as a whole failed.
+func (fetcher *messageFetcher) fetchBatch(user string, ids []string, chunk []int, results []fetchResult) error {
+	basePath, err := url.Parse(fetcher.srv.BasePath)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"format": []string{"metadata"}, "metadataHeaders": fetcher.headers, "alt": []string{"json"}}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, index := range chunk {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<%d>", index))
+		part, _ := writer.CreatePart(header)
+		path := fmt.Sprintf("%s/%s/messages/%s", strings.TrimSuffix(basePath.Path, "/"), url.PathEscape(user), url.PathEscape(ids[index]))
+		fmt.Fprintf(part, "GET %s?%s HTTP/1.1\r\n\r\n", path, query.Encode())
+	}
+
+	_ = writer.Close()
+	batchURL := fmt.Sprintf("%s://%s/batch/gmail/v1", basePath.Scheme, basePath.Host)
+	request, err := http.NewRequest("POST", batchURL, &body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	response, err := fetcher.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+	err = googleapi.CheckResponse(response)
+	if err != nil {
+		return err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("unexpected batch response type %s", response.Header.Get("Content-Type"))
+	}
+
+	answered := map[int]bool{}
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		contentID := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-"))
+		if err != nil || index < 0 || index >= len(results) {
+			continue
+		}
+
+		results[index] = parseBatchPart(part)
+		answered[index] = true
+	}
+
+	for _, index := range chunk {
+		if !answered[index] {
+			results[index] = fetchResult{err: fmt.Errorf("no response in batch for message %s", ids[index])}
+		}
+	}
+
+	return nil
+}
+
+// parseBatchPart decodes the HTTP response embedded in a part of a batch response
+func parseBatchPart(part *multipart.Part) fetchResult {
+	response, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		return fetchResult{err: err}
+	}
+
+	defer func() { _ = response.Body.Close() }()
+	err = googleapi.CheckResponse(response)
+	if err != nil {
+		return fetchResult{err: err}
+	}
+
+	message := &gmail.Message{}
+	err = json.NewDecoder(response.Body).Decode(message)
+	if err != nil {
+		return fetchResult{err: err}
+	}
+
+	return fetchResult{message: message}
+}
+
+// isRateLimited reports whether err is a quota error that is worth retrying
+func isRateLimited(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package command_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestFetchBatch(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages}
+	output, errOutput := runFetchList(t, mock)
+	assert.Equal(t, "", errOutput)
+	assert.Equal(t, 5, len(output))
+	assert.Equal(t, 1, mock.batchRequests)
+	assert.Equal(t, 3, mock.messageRequests)
+}
+
+func TestFetchBatchChunks(t *testing.T) {
+	ids := []string{}
+	messages := map[string]*gmail.Message{}
+	for index := 0; index < 60; index++ {
+		id := fmt.Sprintf("m%d", index)
+		ids = append(ids, id)
+		messages[id] = newListTestMessage(id, "alice@example.com", id, "", int64(index), "INBOX", "UNREAD")
+	}
+
+	mock := &mockGmail{t: t, checks: [][]string{ids}, messages: messages}
+	output, errOutput := runFetchList(t, mock)
+	assert.Equal(t, "", errOutput)
+	assert.Equal(t, 62, len(output))
+	assert.Equal(t, 2, mock.batchRequests)
+	assert.Equal(t, 60, mock.messageRequests)
+}
+
+func TestFetchBatchUnavailable(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages, batchDisabled: true}
+	output, errOutput := runFetchList(t, mock)
+	assert.Equal(t, "", errOutput)
+	assert.Equal(t, 5, len(output))
+	assert.Contains(t, output[1], "Build failed")
+	assert.Equal(t, 1, mock.batchRequests)
+	assert.Equal(t, 3, mock.messageRequests)
+}
+
+func TestFetchRateLimited(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages, rateLimited: map[string]int{"b": 2}}
+	output, errOutput := runFetchList(t, mock)
+	assert.Equal(t, "", errOutput)
+	assert.Equal(t, 5, len(output))
+	assert.Contains(t, output[1], "Build failed")
+	assert.Equal(t, 3, mock.batchRequests)
+	assert.Equal(t, 5, mock.messageRequests)
+}
+
+func TestFetchRateLimitedGivesUp(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages, rateLimited: map[string]int{"b": 10}, batchDisabled: true}
+	output, errOutput := runFetchList(t, mock)
+	assert.Contains(t, errOutput, "Unable to get message b. googleapi: Error 429: Too many concurrent requests for user")
+	assert.Equal(t, 4, len(output))
+	assert.Equal(t, 6, mock.messageRequests)
+}
+
+// runFetchList runs CmdList against mock and returns the output lines after the OAuth prompt along with the error output
+func runFetchList(t *testing.T, mock *mockGmail) ([]string, string) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := mock.start()
+	defer ts.Close()
+	command.BasePath = ts.URL
+	command.FetchBackoff = time.Millisecond
+	defer func() { command.FetchBackoff = time.Second }()
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Int("limit", 100, "doc")
+	assert.Nil(t, command.CmdList(cb)(cli.NewContext(app, set, nil)))
+	lines := strings.Split(writer.String(), "\n")
+	assert.Contains(t, lines[0], "Attempting to open")
+	return lines[1:], errWriter.String()
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// CmdFolders lists the folders in every account along with how many unread messages they hold
+func CmdFolders(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 {
+			return cli.NewExitError("Usage: \"unreadChecker folders\"", 1)
+		}
+
+		accounts, err := newAccounts(c, cmdBuilder)
+		if err != nil {
+			return err
+		}
+
+		providers := []provider{}
+		if gmailConfigured(c) {
+			err = checkFlags(c)
+			if err != nil {
+				return err
+			}
+
+			srv, _, err := getService(c, cmdBuilder)
+			if err != nil {
+				return err
+			}
+
+			user := gmailUser(c)
+			address, err := gmailAddress(srv, user)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+
+			providers = append(providers, gmailProvider{srv: srv, user: user, address: address})
+		}
+
+		for _, account := range accounts {
+			providers = append(providers, account.provider)
+		}
+
+		table := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ACCOUNT\tFOLDER\tUNREAD")
+		for _, account := range providers {
+			folders, err := account.folders()
+			if err != nil {
+				_ = table.Flush()
+				return cli.NewExitError(err.Error(), 1)
+			}
+
+			for _, folder := range folders {
+				unread, err := account.countUnread(folder)
+				if err != nil {
+					_ = table.Flush()
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				fmt.Fprintf(table, "%s\t%s\t%d\n", account.name(), folder, unread)
+			}
+		}
+
+		return table.Flush()
+	}
+}
@@ -0,0 +1,146 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+const (
+	hookOnChange   = "on-change"
+	hookOnIncrease = "on-increase"
+	hookOnZero     = "on-zero"
+	hookOnError    = "on-error"
+)
+
+// hookTimeoutExitCode is the exit code of timeout(1) when the command times out
+const hookTimeoutExitCode = 124
+
+// hook is a shell command that is run when an event happens in watch mode
+type hook struct {
+	event   string
+	command string
+	timeout time.Duration
+}
+
+// hookRunner runs hooks in the background so that a slow hook never delays the next check
+type hookRunner struct {
+	cmdBuilder runner.Builder
+	hooks      []hook
+	errWriter  io.Writer
+	running    sync.WaitGroup
+}
+
+func newHookRunner(c *cli.Context, cmdBuilder runner.Builder, errWriter io.Writer) (*hookRunner, error) {
+	if len(c.StringSlice("hook")) == 0 {
+		return nil, nil
+	}
+
+	hooks := &hookRunner{cmdBuilder: cmdBuilder, errWriter: errWriter}
+	for _, definition := range c.StringSlice("hook") {
+		parsed, err := parseHook(definition, c.Duration("hookTimeout"))
+		if err != nil {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid hook %s: %v", definition, err), 1)
+		}
+
+		hooks.hooks = append(hooks.hooks, parsed)
+	}
+
+	return hooks, nil
+}
+
+// parseHook parses a hook in the format EVENT[:TIMEOUT]=COMMAND
+func parseHook(definition string, defaultTimeout time.Duration) (hook, error) {
+	parts := strings.SplitN(definition, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return hook{}, fmt.Errorf("hooks must be in the format EVENT[:TIMEOUT]=COMMAND")
+	}
+
+	newHook := hook{event: parts[0], command: parts[1], timeout: defaultTimeout}
+	if eventParts := strings.SplitN(parts[0], ":", 2); len(eventParts) == 2 {
+		timeout, err := time.ParseDuration(eventParts[1])
+		if err != nil {
+			return hook{}, err
+		}
+
+		newHook.event = eventParts[0]
+		newHook.timeout = timeout
+	}
+
+	switch newHook.event {
+	case hookOnChange, hookOnIncrease, hookOnZero, hookOnError:
+		return newHook, nil
+	}
+
+	return hook{}, fmt.Errorf("unknown event %s", newHook.event)
+}
+
+// fire runs the hooks for the events caused by a check
+func (hooks *hookRunner) fire(previous, current []unreadCount, checkErr error) {
+	if checkErr != nil {
+		hooks.start(hookOnError, []string{fmt.Sprintf("UNREADCHECKER_ERROR=%v", checkErr)})
+		return
+	}
+
+	for _, change := range findChanges(previous, current) {
+		env := []string{
+			fmt.Sprintf("UNREADCHECKER_ACCOUNT=%s", change.current.Account),
+			fmt.Sprintf("UNREADCHECKER_LABEL=%s", change.current.Label),
+			fmt.Sprintf("UNREADCHECKER_OLD_COUNT=%d", change.previous.Unread),
+			fmt.Sprintf("UNREADCHECKER_NEW_COUNT=%d", change.current.Unread),
+		}
+
+		hooks.start(hookOnChange, env)
+		if change.current.Unread > change.previous.Unread {
+			hooks.start(hookOnIncrease, env)
+		}
+
+		if change.current.Unread == 0 {
+			hooks.start(hookOnZero, env)
+		}
+	}
+}
+
+// start builds the commands for event's hooks and runs them in the background
+func (hooks *hookRunner) start(event string, env []string) {
+	env = append(append(os.Environ(), fmt.Sprintf("UNREADCHECKER_EVENT=%s", event)), env...)
+	for _, eventHook := range hooks.hooks {
+		if eventHook.event != event {
+			continue
+		}
+
+		// timeout(1) takes seconds, not Go durations like 1m0s
+		seconds := strconv.FormatFloat(eventHook.timeout.Seconds(), 'f', -1, 64)
+		cmd := hooks.cmdBuilder.NewWithEnvironment("", env, "timeout", seconds, "sh", "-c", eventHook.command)
+		hooks.running.Add(1)
+		go hooks.run(eventHook, cmd)
+	}
+}
+
+func (hooks *hookRunner) run(eventHook hook, cmd runner.Command) {
+	defer hooks.running.Done()
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == hookTimeoutExitCode {
+		fmt.Fprintf(hooks.errWriter, "Hook %s timed out after %s\n", eventHook.event, eventHook.timeout)
+		return
+	}
+
+	fmt.Fprintf(hooks.errWriter, "Hook %s failed: %v %s\n", eventHook.event, err, strings.TrimSpace(string(output)))
+}
+
+// wait blocks until all running hooks have finished
+func (hooks *hookRunner) wait() {
+	hooks.running.Wait()
+}
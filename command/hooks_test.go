@@ -0,0 +1,149 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckHooks(t *testing.T) {
+	cb, errOutput := runHookCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}, {}},
+		[]string{"on-change=echo change", "on-increase=echo increase", "on-zero:5s=echo zero"},
+		getExpectedHook("30", "echo change", "on-change", 1, 2),
+		getExpectedHook("30", "echo increase", "on-increase", 1, 2),
+		getExpectedHook("30", "echo change", "on-change", 2, 0),
+		getExpectedHook("5", "echo zero", "on-zero", 2, 0),
+	)
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckHooksNoChange(t *testing.T) {
+	cb, errOutput := runHookCheck(t, [][]string{{"a"}, {"b"}}, []string{"on-change=echo change"})
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckHooksFailure(t *testing.T) {
+	cb, errOutput := runHookCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		[]string{"on-increase=false"},
+		runner.NewExpectedCommand("", "timeout 30 sh -c false", "hook output", 1).WithEnvironment(getHookEnvironment("on-increase", 1, 2)),
+	)
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "Hook on-increase failed: exit status 1 hook output\n", errOutput)
+}
+
+func TestCmdCheckHooksTimeout(t *testing.T) {
+	cb, errOutput := runHookCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		[]string{"on-increase:1s=sleep 5"},
+		runner.NewExpectedCommand("", "timeout 1 sh -c sleep 5", "", 124).WithEnvironment(getHookEnvironment("on-increase", 1, 2)),
+	)
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "Hook on-increase timed out after 1s\n", errOutput)
+}
+
+func TestCmdCheckHooksTimeoutSeconds(t *testing.T) {
+	cb, errOutput := runHookCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		[]string{"on-change:1m=echo change", "on-increase:500ms=echo increase"},
+		getExpectedHook("60", "echo change", "on-change", 1, 2),
+		getExpectedHook("0.5", "echo increase", "on-increase", 1, 2),
+	)
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckHooksOnError(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPIInboxFailure(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	command.MaxWatchIterations = 1
+	defer func() { command.MaxWatchIterations = 0 }()
+	errorMessage := "Unable to check inbox. googleapi: got HTTP response code 500 with body: "
+	hook := runner.NewExpectedCommand("", "timeout 30 sh -c echo error", "", 0).WithEnvironment(
+		append(os.Environ(), "UNREADCHECKER_EVENT=on-error", "UNREADCHECKER_ERROR="+errorMessage),
+	)
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t), hook}, AnyOrder: true}
+	app, _, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Bool("watch", true, "doc")
+	set.Var(&cli.StringSlice{"on-error=echo error", "on-change=echo change"}, "hook", "doc")
+	set.Duration("hookTimeout", 30*time.Second, "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, errorMessage+"\n", errWriter.String())
+}
+
+func TestCmdCheckHooksInvalid(t *testing.T) {
+	for definition, expectedError := range map[string]string{
+		"on-change":             "Invalid hook on-change: hooks must be in the format EVENT[:TIMEOUT]=COMMAND",
+		"on-foo=echo foo":       "Invalid hook on-foo=echo foo: unknown event on-foo",
+		"on-zero:soon=echo foo": `Invalid hook on-zero:soon=echo foo: time: invalid duration "soon"`,
+	} {
+		testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+		assert.Nil(t, os.MkdirAll(testFolder, 0777))
+		app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+		set.Var(&cli.StringSlice{definition}, "hook", "doc")
+		cb := &runner.Test{}
+		assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), expectedError)
+		removeFile(t, testFolder)
+	}
+}
+
+// runHookCheck runs one watch iteration per entry in checks with the given hooks
+func runHookCheck(t *testing.T, checks [][]string, hooks []string, expectedHooks ...*runner.ExpectedCommand) (*runner.Test, string) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPISequence(t, checks, testMessages)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	command.MaxWatchIterations = len(checks)
+	defer func() { command.MaxWatchIterations = 0 }()
+	cb := &runner.Test{ExpectedCommands: append([]*runner.ExpectedCommand{getBrowserCommand(t)}, expectedHooks...), AnyOrder: true}
+	app, _, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Bool("watch", true, "doc")
+	set.Duration("interval", time.Millisecond, "doc")
+	hookSlice := cli.StringSlice(hooks)
+	set.Var(&hookSlice, "hook", "doc")
+	set.Duration("hookTimeout", 30*time.Second, "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	return cb, errWriter.String()
+}
+
+func getExpectedHook(timeout, hookCommand, event string, oldCount, newCount int) *runner.ExpectedCommand {
+	return runner.NewExpectedCommand("", "timeout "+timeout+" sh -c "+hookCommand, "", 0).WithEnvironment(getHookEnvironment(event, oldCount, newCount))
+}
+
+func getHookEnvironment(event string, oldCount, newCount int) []string {
+	return append(
+		os.Environ(),
+		"UNREADCHECKER_EVENT="+event,
+		"UNREADCHECKER_ACCOUNT=me@example.com",
+		"UNREADCHECKER_LABEL=INBOX",
+		"UNREADCHECKER_OLD_COUNT="+strconv.Itoa(oldCount),
+		"UNREADCHECKER_NEW_COUNT="+strconv.Itoa(newCount),
+	)
+}
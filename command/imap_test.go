@@ -0,0 +1,427 @@
+package command_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// fakeIMAPServer is an IMAP server with one user whose folders hold unseen message UIDs
+type fakeIMAPServer struct {
+	listener net.Listener
+	password string
+	// starttls is offered for upgrading plain text connections when it is set
+	starttls *tls.Config
+	// loginDisabled is advertised on plain text connections
+	loginDisabled bool
+	// folders are listed in order, and the ones without UIDs are not selectable
+	folders  []string
+	unseen   map[string][]int
+	mutex    sync.Mutex
+	commands []string
+	idling   map[net.Conn]bool
+}
+
+func TestCmdCheckIMAP(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n", output)
+	assert.Equal(t, []string{"CAPABILITY", `LOGIN "alice" "secret"`, `STATUS "INBOX" (UNSEEN)`, "LOGOUT"}, server.getCommands())
+}
+
+func TestCmdCheckIMAPStartTLS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	server.starttls = tlsServer.TLS
+	server.loginDisabled = true
+	caFile := filepath.Join(os.TempDir(), "testUnreadCheckerCA.pem")
+	defer removeFile(t, caFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0600))
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?caFile=" + caFile}, "imap", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n", output)
+	assert.Equal(t, []string{"CAPABILITY", "STARTTLS", "CAPABILITY", `LOGIN "alice" "secret"`, `STATUS "INBOX" (UNSEEN)`, "LOGOUT"}, server.getCommands())
+}
+
+func TestCmdCheckIMAPPlaintextRefused(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String()}, "imap", "doc")
+	})
+	assert.EqualError(
+		t,
+		err,
+		fmt.Sprintf(
+			"IMAP server %s does not support STARTTLS, use imaps:// or add allowPlaintext=true to send the password in plain text",
+			server.listener.Addr().String(),
+		),
+	)
+	assert.Equal(t, []string{"CAPABILITY"}, server.getCommands())
+}
+
+func TestCmdCheckIMAPLoginDisabled(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	server.loginDisabled = true
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	})
+	assert.EqualError(t, err, fmt.Sprintf("IMAP server %s does not allow LOGIN", server.listener.Addr().String()))
+	assert.Equal(t, []string{"CAPABILITY"}, server.getCommands())
+}
+
+func TestCmdCheckIMAPTLS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	server := startFakeIMAPServer(t, tlsServer.TLS)
+	defer server.close()
+	caFile := filepath.Join(os.TempDir(), "testUnreadCheckerCA.pem")
+	defer removeFile(t, caFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0600))
+	assert.Nil(t, os.Setenv("TEST_IMAP_PASSWORD", "secret"))
+	defer func() { assert.Nil(t, os.Unsetenv("TEST_IMAP_PASSWORD")) }()
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		account := fmt.Sprintf("imaps://alice@%s/Work%%20Stuff?passwordEnv=TEST_IMAP_PASSWORD&caFile=%s", server.listener.Addr().String(), caFile)
+		set.Var(&cli.StringSlice{account}, "imap", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":1,"counts":[{"account":"alice@127.0.0.1","label":"Work Stuff","unread":1,"messages":1}]}`+"\n", output)
+	assert.Contains(t, server.getCommands(), `STATUS "Work Stuff" (UNSEEN)`)
+}
+
+func TestCmdCheckIMAPWithGmail(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	set.String("format", "json", "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	output := writer.String()
+	assert.Equal(
+		t,
+		`{"total":7,"counts":[{"account":"me@example.com","label":"INBOX","unread":4,"messages":4},`+
+			`{"account":"alice@127.0.0.1","label":"INBOX","unread":3,"messages":3}]}`+"\n",
+		output[strings.Index(output, "\n")+1:],
+	)
+}
+
+func TestCmdCheckIMAPLoginFailure(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:wrong@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	})
+	assert.EqualError(
+		t,
+		err,
+		fmt.Sprintf("Unable to log in to IMAP server %s as alice: NO [AUTHENTICATIONFAILED] Invalid credentials", server.listener.Addr().String()),
+	)
+}
+
+func TestCmdCheckIMAPMissingFolder(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "/Missing?allowPlaintext=true"}, "imap", "doc")
+	})
+	assert.EqualError(t, err, "Unable to check IMAP folder Missing: NO Mailbox doesn't exist")
+}
+
+func TestCmdCheckIMAPInvalidAccount(t *testing.T) {
+	for account, expected := range map[string]string{
+		"imaps://mail.example.com":                           "Invalid imap account: imaps://mail.example.com is not in the format imaps://USER@HOST/MAILBOX",
+		"pop3://alice@mail.example.com":                      "Invalid imap scheme: pop3",
+		"imaps://alice@mail.example.com?caFile=/nonexistent": "Unable to read caFile: open /nonexistent: no such file or directory",
+	} {
+		_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+			set.Var(&cli.StringSlice{account}, "imap", "doc")
+		})
+		assert.EqualError(t, err, expected, account)
+	}
+}
+
+func TestCmdFolders(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := (&mockGmail{t: t, checks: [][]string{{"e", "d", "c", "b", "a"}}, messages: categoryTestMessages}).start()
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	assert.Nil(t, command.CmdFolders(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	output := writer.String()
+	assert.Equal(
+		t,
+		strings.Join([]string{
+			"ACCOUNT          FOLDER               UNREAD",
+			"me@example.com   INBOX                5",
+			"me@example.com   CATEGORY_PERSONAL    2",
+			"me@example.com   CATEGORY_PROMOTIONS  1",
+			"me@example.com   CATEGORY_SOCIAL      1",
+			"me@example.com   CATEGORY_UPDATES     1",
+			"alice@127.0.0.1  INBOX                3",
+			"alice@127.0.0.1  Work Stuff           1",
+			"alice@127.0.0.1  Old Mail             0",
+			"",
+		}, "\n"),
+		output[strings.Index(output, "\n")+1:],
+	)
+	assert.Contains(t, server.getCommands(), `STATUS "Old Mail" (UNSEEN)`)
+}
+
+func TestCmdFoldersUsage(t *testing.T) {
+	app, _, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	assert.Nil(t, set.Parse([]string{"foo"}))
+	assert.EqualError(t, command.CmdFolders(&runner.Test{})(cli.NewContext(app, set, nil)), `Usage: "unreadChecker folders"`)
+}
+
+// runIMAPCheck runs CmdCheck without any Gmail flags
+func runIMAPCheck(t *testing.T, setFlags func(*flag.FlagSet)) (string, error) {
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	setFlags(set)
+	cb := &runner.Test{}
+	err := command.CmdCheck(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand(nil), cb.ExpectedCommands)
+	return writer.String(), err
+}
+
+func startFakeIMAPServer(t *testing.T, tlsConfig *tls.Config) *fakeIMAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	server := &fakeIMAPServer{
+		listener: listener,
+		password: "secret",
+		folders:  []string{"INBOX", "[Gmail]", "Work Stuff", "Old Mail"},
+		unseen:   map[string][]int{"INBOX": {3, 7, 9}, "Work Stuff": {2}, "Old Mail": {}},
+		idling:   map[net.Conn]bool{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.handle(t, conn)
+		}
+	}()
+
+	return server
+}
+
+func (server *fakeIMAPServer) handle(t *testing.T, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		_, _ = conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+	}
+
+	reply("* OK fake IMAP server ready")
+	_, secure := conn.(*tls.Conn)
+	selected := ""
+	idleTag := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+		server.mutex.Lock()
+		server.commands = append(server.commands, parts[len(parts)-1])
+		server.mutex.Unlock()
+		if parts[0] == "DONE" {
+			server.setIdling(conn, false)
+			reply(idleTag + " OK IDLE terminated")
+			continue
+		}
+
+		tag, command := parts[0], parts[1]
+		mailbox := ""
+		if start := strings.Index(command, `"`); start != -1 {
+			mailbox = command[start+1 : start+1+strings.Index(command[start+1:], `"`)]
+		}
+
+		uids, exists := server.getUnseen(mailbox)
+		switch {
+		case command == "CAPABILITY":
+			capabilities := "* CAPABILITY IMAP4rev1"
+			if server.starttls != nil && !secure {
+				capabilities += " STARTTLS"
+			}
+
+			if server.loginDisabled && !secure {
+				capabilities += " LOGINDISABLED"
+			}
+
+			reply(capabilities, tag+" OK CAPABILITY completed")
+		case command == "STARTTLS" && server.starttls != nil && !secure:
+			reply(tag + " OK Begin TLS negotiation now")
+			conn = tls.Server(conn, server.starttls)
+			reader = bufio.NewReader(conn)
+			secure = true
+		case strings.HasPrefix(command, "LOGIN ") && server.loginDisabled && !secure:
+			reply(tag + " BAD LOGIN is disabled")
+		case strings.HasPrefix(command, "LOGIN "):
+			if command != fmt.Sprintf(`LOGIN "alice" "%s"`, server.password) {
+				reply(tag + " NO [AUTHENTICATIONFAILED] Invalid credentials")
+				continue
+			}
+
+			reply(tag + " OK LOGIN completed")
+		case command == `LIST "" "*"`:
+			for _, folder := range server.folders {
+				uids, _ := server.getUnseen(folder)
+				switch {
+				case uids == nil:
+					reply(fmt.Sprintf(`* LIST (\HasChildren \Noselect) "/" "%s"`, folder))
+				case strings.Contains(folder, " "):
+					reply(fmt.Sprintf(`* LIST (\HasNoChildren) "/" {%d}`, len(folder)) + "\r\n" + folder)
+				default:
+					reply(fmt.Sprintf(`* LIST (\HasNoChildren) "/" %s`, folder))
+				}
+			}
+
+			reply(tag + " OK LIST completed")
+		case strings.HasPrefix(command, "STATUS ") && exists:
+			reply(fmt.Sprintf(`* STATUS "%s" (UNSEEN %d)`, mailbox, len(uids)), tag+" OK STATUS completed")
+		case strings.HasPrefix(command, "EXAMINE ") && exists:
+			selected = mailbox
+			reply("* 12 EXISTS", "* OK [UIDVALIDITY 1] UIDs valid", tag+" OK [READ-ONLY] EXAMINE completed")
+		case command == "UID SEARCH UNSEEN":
+			search := "* SEARCH"
+			uids, _ := server.getUnseen(selected)
+			for _, uid := range uids {
+				search += fmt.Sprintf(" %d", uid)
+			}
+
+			reply(search, tag+" OK SEARCH completed")
+		case command == "IDLE":
+			idleTag = tag
+			reply("+ idling")
+			server.setIdling(conn, true)
+		case command == "LOGOUT":
+			reply("* BYE logging out", tag+" OK LOGOUT completed")
+			return
+		default:
+			reply(tag + " NO Mailbox doesn't exist")
+		}
+	}
+}
+
+func (server *fakeIMAPServer) getUnseen(mailbox string) ([]int, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	uids, exists := server.unseen[mailbox]
+	return uids, exists
+}
+
+func (server *fakeIMAPServer) setUnseen(mailbox string, uids []int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.unseen[mailbox] = uids
+}
+
+func (server *fakeIMAPServer) setIdling(conn net.Conn, idling bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if idling {
+		server.idling[conn] = true
+	} else {
+		delete(server.idling, conn)
+	}
+}
+
+// push sends an untagged response to every idling connection
+func (server *fakeIMAPServer) push(line string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for conn := range server.idling {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+}
+
+// dropIdle closes every idling connection
+func (server *fakeIMAPServer) dropIdle() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for conn := range server.idling {
+		_ = conn.Close()
+		delete(server.idling, conn)
+	}
+}
+
+// waitForCommand waits for the server to have received command count times
+func (server *fakeIMAPServer) waitForCommand(t *testing.T, command string, count int) {
+	for attempt := 0; attempt < 200; attempt++ {
+		received := 0
+		for _, candidate := range server.getCommands() {
+			if candidate == command {
+				received++
+			}
+		}
+
+		if received >= count {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("%s was not received %d times", command, count)
+}
+
+func (server *fakeIMAPServer) getCommands() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string{}, server.commands...)
+}
+
+func (server *fakeIMAPServer) close() {
+	_ = server.listener.Close()
+}
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IMAPIdleRefresh is how long an IDLE command runs before it is re-issued.  Servers may end IDLE after 29 minutes.
+var IMAPIdleRefresh = 25 * time.Minute
+
+// imapIdler keeps an IDLE connection open to a folder and reports the updates the server pushes
+type imapIdler struct {
+	account   *imapProvider
+	folder    string
+	changes   chan<- struct{}
+	errWriter io.Writer
+	done      chan struct{}
+	mutex     sync.Mutex
+	conn      *imapConn
+}
+
+func (account *imapProvider) watch(folder string, changes chan<- struct{}, errWriter io.Writer) func() {
+	idler := &imapIdler{account: account, folder: folder, changes: changes, errWriter: errWriter, done: make(chan struct{})}
+	go idler.run()
+	return idler.stop
+}
+
+// run idles until stopped
+func (idler *imapIdler) run() {
+	describe := func() string { return fmt.Sprintf("IMAP IDLE for %s %s", idler.account.name(), idler.folder) }
+	keepConnected(describe, idler.done, idler.errWriter, idler.session)
+}
+
+// session connects and idles until the connection fails.  It returns whether the server accepted IDLE.
+// After a reconnect a change is reported since updates may have been missed while disconnected.
+func (idler *imapIdler) session(reconnect bool) (bool, error) {
+	conn, err := idler.account.connect()
+	if err != nil {
+		return false, err
+	}
+
+	if !idler.setConn(conn) {
+		return false, nil
+	}
+
+	defer func() { _ = conn.conn.Close() }()
+	_, err = conn.command("EXAMINE %s", imapQuote(idler.folder))
+	if err != nil {
+		return false, fmt.Errorf("Unable to open IMAP folder %s: %v", idler.folder, err)
+	}
+
+	idled := false
+	for {
+		conn.tag++
+		tag := fmt.Sprintf("a%d", conn.tag)
+		_ = conn.conn.SetDeadline(time.Now().Add(IMAPIdleRefresh + 30*time.Second))
+		_, err = fmt.Fprintf(conn.conn, "%s IDLE\r\n", tag)
+		if err != nil {
+			return idled, err
+		}
+
+		// Ending IDLE makes the server finish the command, after which it is started again
+		refresh := time.AfterFunc(IMAPIdleRefresh, func() { _, _ = fmt.Fprint(conn.conn, "DONE\r\n") })
+		err = idler.readUpdates(conn, tag, func() {
+			idled = true
+			if reconnect {
+				notifyChange(idler.changes)
+				reconnect = false
+			}
+		})
+		refresh.Stop()
+		if err != nil {
+			return idled, err
+		}
+	}
+}
+
+// readUpdates reads responses until the IDLE command tagged tag completes, reporting a change for each update
+func (idler *imapIdler) readUpdates(conn *imapConn, tag string, accepted func()) error {
+	for {
+		line, err := conn.readLine()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			accepted()
+		case strings.HasPrefix(line, tag+" "):
+			result := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(result, "OK") {
+				return fmt.Errorf("%s", result)
+			}
+
+			return nil
+		case isIMAPUpdate(line):
+			notifyChange(idler.changes)
+		}
+	}
+}
+
+// isIMAPUpdate is whether a response means the unread messages in the folder may have changed
+func isIMAPUpdate(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "*" {
+		return false
+	}
+
+	switch strings.ToUpper(fields[2]) {
+	case "EXISTS", "EXPUNGE", "FETCH":
+		return true
+	}
+
+	return false
+}
+
+// setConn records the current connection so it can be closed by stop.  It returns false if already stopped.
+func (idler *imapIdler) setConn(conn *imapConn) bool {
+	idler.mutex.Lock()
+	defer idler.mutex.Unlock()
+	if idler.stopped() {
+		_ = conn.conn.Close()
+		return false
+	}
+
+	idler.conn = conn
+	return true
+}
+
+func (idler *imapIdler) stopped() bool {
+	select {
+	case <-idler.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (idler *imapIdler) stop() {
+	idler.mutex.Lock()
+	defer idler.mutex.Unlock()
+	close(idler.done)
+	if idler.conn != nil {
+		_ = idler.conn.conn.Close()
+	}
+}
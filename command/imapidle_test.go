@@ -0,0 +1,78 @@
+package command_test
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckIMAPPush(t *testing.T) {
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	go func() {
+		server.waitForCommand(t, "IDLE", 1)
+		server.waitForCommand(t, "LOGOUT", 1)
+		server.setUnseen("INBOX", []int{3, 7, 9, 13})
+		server.push("* 13 EXISTS")
+	}()
+
+	output, errOutput := runIMAPWatch(t, server, time.Hour)
+	assert.Equal(t, "3\n4\n", output)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckIMAPPushRefresh(t *testing.T) {
+	command.IMAPIdleRefresh = 20 * time.Millisecond
+	defer func() { command.IMAPIdleRefresh = 25 * time.Minute }()
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	output, errOutput := runIMAPWatch(t, server, 200*time.Millisecond)
+	assert.Equal(t, "3\n3\n", output)
+	assert.Equal(t, "", errOutput)
+	idles := 0
+	for _, received := range server.getCommands() {
+		if received == "IDLE" {
+			idles++
+		}
+	}
+
+	assert.True(t, idles > 2, "IDLE should be re-issued")
+	assert.Contains(t, server.getCommands(), "DONE")
+}
+
+func TestCmdCheckIMAPPushReconnect(t *testing.T) {
+	command.PushReconnectBackoff = 5 * time.Millisecond
+	defer func() { command.PushReconnectBackoff = time.Second }()
+	server := startFakeIMAPServer(t, nil)
+	defer server.close()
+	go func() {
+		server.waitForCommand(t, "IDLE", 1)
+		server.waitForCommand(t, "LOGOUT", 1)
+		server.setUnseen("INBOX", []int{3})
+		server.dropIdle()
+	}()
+
+	output, errOutput := runIMAPWatch(t, server, time.Hour)
+	assert.Equal(t, "3\n1\n", output)
+	assert.Equal(t, "IMAP IDLE for alice@127.0.0.1 INBOX failed, reconnecting in 5ms: EOF\n", errOutput)
+	server.waitForCommand(t, "IDLE", 2)
+}
+
+// runIMAPWatch runs two checks of the fake server in watch mode with push enabled
+func runIMAPWatch(t *testing.T, server *fakeIMAPServer, interval time.Duration) (string, string) {
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	app, writer, errWriter := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{"imap://alice:secret@" + server.listener.Addr().String() + "?allowPlaintext=true"}, "imap", "doc")
+	set.Bool("watch", true, "doc")
+	set.Bool("push", true, "doc")
+	set.Duration("interval", interval, "doc")
+	assert.Nil(t, command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)))
+	return writer.String(), errWriter.String()
+}
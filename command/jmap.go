@@ -0,0 +1,363 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	jmapMailCapability = "urn:ietf:params:jmap:mail"
+	jmapSessionPath    = "/.well-known/jmap"
+)
+
+// jmapProvider is a JMAP (RFC 8620/8621) account authenticated with a bearer token
+type jmapProvider struct {
+	sessionURL string
+	token      string
+	mutex      sync.Mutex
+	session    *jmapSession
+}
+
+// jmapSession is the part of the session resource that is needed to find the mail account and API
+type jmapSession struct {
+	Username        string            `json:"username"`
+	APIURL          string            `json:"apiUrl"`
+	EventSourceURL  string            `json:"eventSourceUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+}
+
+// jmapMailbox is a mailbox from Mailbox/get
+type jmapMailbox struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	ParentID      string `json:"parentId"`
+	Role          string `json:"role"`
+	UnreadEmails  int    `json:"unreadEmails"`
+	UnreadThreads int    `json:"unreadThreads"`
+	// path is the name along with the names of the mailboxes it is in
+	path string
+}
+
+// newJMAPProviders parses the --jmap accounts
+func newJMAPProviders(c *cli.Context) ([]providerFolder, error) {
+	accounts := []providerFolder{}
+	for _, value := range globalStringSlice(c, "jmap") {
+		server, err := url.Parse(value)
+		if err != nil || server.Host == "" || (server.Scheme != "https" && server.Scheme != "http") {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid jmap account: %s is not in the format https://HOST[/SESSION_PATH]?tokenEnv=VAR", redactURL(value)), 1)
+		}
+
+		query := server.Query()
+		account := &jmapProvider{token: os.Getenv(query.Get("tokenEnv"))}
+		if account.token == "" {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid jmap account: %s has no token, set tokenEnv to an environment variable holding it", server.Host), 1)
+		}
+
+		mailbox := query.Get("mailbox")
+		if mailbox == "" {
+			mailbox = defaultLabel
+		}
+
+		if server.Path == "" || server.Path == "/" {
+			server.Path = jmapSessionPath
+		}
+
+		server.RawQuery = ""
+		account.sessionURL = server.String()
+		accounts = append(accounts, providerFolder{provider: account, folder: mailbox})
+	}
+
+	return accounts, nil
+}
+
+// name is the username from the session, or the server until the session has been loaded
+func (account *jmapProvider) name() string {
+	account.mutex.Lock()
+	defer account.mutex.Unlock()
+	if account.session != nil && account.session.Username != "" {
+		return account.session.Username
+	}
+
+	parsed, _ := url.Parse(account.sessionURL)
+	return parsed.Host
+}
+
+func (account *jmapProvider) folders() ([]string, error) {
+	mailboxes, err := account.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]string, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		folders = append(folders, mailbox.path)
+	}
+
+	sortFolders(folders)
+	return folders, nil
+}
+
+func (account *jmapProvider) countUnread(folder string) (int, error) {
+	mailbox, err := account.findMailbox(folder)
+	if err != nil {
+		return 0, err
+	}
+
+	return mailbox.UnreadEmails, nil
+}
+
+func (account *jmapProvider) countUnreadThreads(folder string) (int, error) {
+	mailbox, err := account.findMailbox(folder)
+	if err != nil {
+		return 0, err
+	}
+
+	return mailbox.UnreadThreads, nil
+}
+
+func (account *jmapProvider) listUnread(folder string, limit int) ([]string, error) {
+	mailbox, err := account.findMailbox(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := map[string]interface{}{
+		"filter": map[string]interface{}{"inMailbox": mailbox.ID, "notKeyword": "$seen"},
+		"sort":   []map[string]interface{}{{"property": "receivedAt", "isAscending": false}},
+	}
+	if limit > 0 {
+		arguments["limit"] = limit
+	}
+
+	result := struct {
+		IDs []string `json:"ids"`
+	}{}
+	err = account.call("Email/query", arguments, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.IDs, nil
+}
+
+// watch listens to the event source for state changes to mailboxes and emails
+func (account *jmapProvider) watch(folder string, changes chan<- struct{}, errWriter io.Writer) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	describe := func() string { return "JMAP push for " + account.name() }
+	go keepConnected(describe, ctx.Done(), errWriter, func(reconnect bool) (bool, error) {
+		return account.listen(ctx, reconnect, changes)
+	})
+
+	return cancel
+}
+
+// listen reads the event source until it ends, reporting a change for each state event.
+// After a reconnect a change is reported since updates may have been missed while disconnected.
+func (account *jmapProvider) listen(ctx context.Context, reconnect bool, changes chan<- struct{}) (bool, error) {
+	session, err := account.getSession()
+	if err != nil {
+		return false, err
+	}
+
+	if session.EventSourceURL == "" {
+		return false, fmt.Errorf("the server does not support push")
+	}
+
+	eventURL := strings.NewReplacer("{types}", "Mailbox,Email", "{closeafter}", "no", "{ping}", "60").Replace(session.EventSourceURL)
+	request, err := http.NewRequest("GET", eventURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Accept", "text/event-stream")
+	request.Header.Set("Authorization", "Bearer "+account.token)
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	if reconnect {
+		notifyChange(changes)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	event := "message"
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			event = "message"
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:") && event == "state":
+			notifyChange(changes)
+		}
+	}
+
+	if scanner.Err() != nil {
+		return true, scanner.Err()
+	}
+
+	return true, io.EOF
+}
+
+// findMailbox finds a mailbox by its path, or by its role such as inbox or archive
+func (account *jmapProvider) findMailbox(folder string) (*jmapMailbox, error) {
+	mailboxes, err := account.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mailbox := range mailboxes {
+		if mailbox.path == folder {
+			return mailbox, nil
+		}
+	}
+
+	for _, mailbox := range mailboxes {
+		if mailbox.Role != "" && strings.EqualFold(mailbox.Role, folder) {
+			return mailbox, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to check JMAP mailbox %s: no such mailbox for %s", folder, account.name())
+}
+
+func (account *jmapProvider) mailboxes() ([]*jmapMailbox, error) {
+	result := struct {
+		List []*jmapMailbox `json:"list"`
+	}{}
+	err := account.call("Mailbox/get", map[string]interface{}{
+		"ids":        nil,
+		"properties": []string{"id", "name", "parentId", "role", "unreadEmails", "unreadThreads"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*jmapMailbox, len(result.List))
+	for _, mailbox := range result.List {
+		byID[mailbox.ID] = mailbox
+	}
+
+	for _, mailbox := range result.List {
+		mailbox.path = mailbox.Name
+		seen := map[string]bool{mailbox.ID: true}
+		for parent := byID[mailbox.ParentID]; parent != nil && !seen[parent.ID]; parent = byID[parent.ParentID] {
+			seen[parent.ID] = true
+			mailbox.path = parent.Name + "/" + mailbox.path
+		}
+	}
+
+	return result.List, nil
+}
+
+// call makes a single method call in the primary mail account and decodes its result
+func (account *jmapProvider) call(method string, arguments map[string]interface{}, result interface{}) error {
+	session, err := account.getSession()
+	if err != nil {
+		return err
+	}
+
+	arguments["accountId"] = session.PrimaryAccounts[jmapMailCapability]
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       []string{"urn:ietf:params:jmap:core", jmapMailCapability},
+		"methodCalls": []interface{}{[]interface{}{method, arguments, "0"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	response := struct {
+		MethodResponses [][]json.RawMessage `json:"methodResponses"`
+	}{}
+	err = account.request("POST", session.APIURL, bytes.NewReader(body), &response)
+	if err != nil {
+		return fmt.Errorf("Unable to call JMAP %s: %v", method, err)
+	}
+
+	if len(response.MethodResponses) != 1 || len(response.MethodResponses[0]) < 2 {
+		return fmt.Errorf("Unable to call JMAP %s: unexpected response", method)
+	}
+
+	name := ""
+	_ = json.Unmarshal(response.MethodResponses[0][0], &name)
+	if name == "error" {
+		methodErr := struct {
+			Type        string `json:"type"`
+			Description string `json:"description"`
+		}{}
+		_ = json.Unmarshal(response.MethodResponses[0][1], &methodErr)
+		return fmt.Errorf("Unable to call JMAP %s: %s %s", method, methodErr.Type, methodErr.Description)
+	}
+
+	return json.Unmarshal(response.MethodResponses[0][1], result)
+}
+
+// getSession fetches the session resource the first time it is needed
+func (account *jmapProvider) getSession() (*jmapSession, error) {
+	account.mutex.Lock()
+	session := account.session
+	account.mutex.Unlock()
+	if session != nil {
+		return session, nil
+	}
+
+	session = &jmapSession{}
+	err := account.request("GET", account.sessionURL, nil, session)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get JMAP session from %s: %v", account.sessionURL, err)
+	}
+
+	if session.APIURL == "" || session.PrimaryAccounts[jmapMailCapability] == "" {
+		return nil, fmt.Errorf("Unable to get JMAP session from %s: no mail account", account.sessionURL)
+	}
+
+	account.mutex.Lock()
+	account.session = session
+	account.mutex.Unlock()
+	return session, nil
+}
+
+func (account *jmapProvider) request(method, requestURL string, body io.Reader, result interface{}) error {
+	request, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+account.token)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
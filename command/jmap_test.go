@@ -0,0 +1,344 @@
+package command_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// fakeJMAPServer is a JMAP server with one account whose mailboxes hold unread email ids, newest first
+type fakeJMAPServer struct {
+	*httptest.Server
+	t         *testing.T
+	mutex     sync.Mutex
+	unread    map[string][]string
+	calls     []string
+	listeners []chan string
+	// queryLimit clamps the ids Email/query returns, as servers may for a query without a limit
+	queryLimit int
+}
+
+var fakeJMAPMailboxes = []map[string]interface{}{
+	{"id": "m1", "name": "Inbox", "parentId": nil, "role": "inbox"},
+	{"id": "m2", "name": "Archive", "parentId": nil, "role": "archive"},
+	{"id": "m3", "name": "Lists", "parentId": nil, "role": nil},
+	{"id": "m4", "name": "Go", "parentId": "m3", "role": nil},
+}
+
+func TestCmdCheckJMAP(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	output, err := runJMAPCheck(t, server.URL, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":2,"counts":[{"account":"alice@example.com","label":"INBOX","unread":2,"messages":2}]}`+"\n", output)
+	assert.Equal(t, []string{"Mailbox/get"}, server.getCalls())
+}
+
+func TestCmdCheckJMAPClampedQuery(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	server.queryLimit = 1
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	output, err := runJMAPCheck(t, server.URL, func(set *flag.FlagSet) {
+		set.Bool("watch", true, "doc")
+		set.Bool("notify", true, "doc")
+		set.Duration("interval", time.Millisecond, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n2\n", output)
+	assert.Equal(t, []string{"Mailbox/get", "Mailbox/get", "Email/query m1", "Mailbox/get", "Mailbox/get", "Email/query m1"}, server.getCalls())
+}
+
+func TestCmdCheckJMAPThreads(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	output, err := runJMAPCheck(t, server.URL+"/jmap/session?mailbox=Lists/Go", func(set *flag.FlagSet) {
+		set.String("count", "both", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "3 (2 threads)\n", output)
+}
+
+func TestCmdCheckJMAPUnauthorized(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	assert.Nil(t, os.Setenv("TEST_JMAP_TOKEN", "wrong"))
+	_, err := runJMAPCheck(t, server.URL, func(*flag.FlagSet) {})
+	assert.EqualError(t, err, fmt.Sprintf("Unable to get JMAP session from %s/.well-known/jmap: unexpected status 401 Unauthorized", server.URL))
+}
+
+func TestCmdCheckJMAPMissingMailbox(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	_, err := runJMAPCheck(t, server.URL+"?mailbox=Missing", func(*flag.FlagSet) {})
+	assert.EqualError(t, err, "Unable to check JMAP mailbox Missing: no such mailbox for alice@example.com")
+}
+
+func TestCmdCheckJMAPInvalidAccount(t *testing.T) {
+	for account, expected := range map[string]string{
+		"ftp://jmap.example.com":                        "Invalid jmap account: ftp://jmap.example.com is not in the format https://HOST[/SESSION_PATH]?tokenEnv=VAR",
+		"https://jmap.example.com":                      "Invalid jmap account: jmap.example.com has no token, set tokenEnv to an environment variable holding it",
+		"https://jmap.example.com?tokenEnv=UNSET_TOKEN": "Invalid jmap account: jmap.example.com has no token, set tokenEnv to an environment variable holding it",
+	} {
+		_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+			set.Var(&cli.StringSlice{account}, "jmap", "doc")
+		})
+		assert.EqualError(t, err, expected, account)
+	}
+}
+
+func TestCmdFoldersJMAP(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	assert.Nil(t, os.Setenv("TEST_JMAP_TOKEN", "secret"))
+	defer func() { assert.Nil(t, os.Unsetenv("TEST_JMAP_TOKEN")) }()
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{server.URL + "?tokenEnv=TEST_JMAP_TOKEN"}, "jmap", "doc")
+	assert.Nil(t, command.CmdFolders(&runner.Test{})(cli.NewContext(app, set, nil)))
+	assert.Equal(
+		t,
+		strings.Join([]string{
+			"ACCOUNT            FOLDER    UNREAD",
+			"alice@example.com  Inbox     2",
+			"alice@example.com  Archive   0",
+			"alice@example.com  Lists     0",
+			"alice@example.com  Lists/Go  3",
+			"",
+		}, "\n"),
+		writer.String(),
+	)
+}
+
+func TestCmdCheckJMAPPush(t *testing.T) {
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	go func() {
+		server.waitForListener()
+		server.setUnread("m1", []string{"e5", "e2", "e1"})
+		server.pushState()
+	}()
+
+	output, errOutput := runJMAPWatch(t, server.URL)
+	assert.Equal(t, "2\n3\n", output)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckJMAPPushReconnect(t *testing.T) {
+	command.PushReconnectBackoff = 5 * time.Millisecond
+	defer func() { command.PushReconnectBackoff = time.Second }()
+	server := startFakeJMAPServer(t)
+	defer server.Close()
+	go func() {
+		server.waitForListener()
+		server.setUnread("m1", []string{"e1"})
+		server.dropListeners()
+	}()
+
+	output, errOutput := runJMAPWatch(t, server.URL)
+	assert.Equal(t, "2\n1\n", output)
+	assert.Equal(t, "JMAP push for alice@example.com failed, reconnecting in 5ms: EOF\n", errOutput)
+}
+
+// runJMAPCheck checks account, adding the test token unless another one is set
+func runJMAPCheck(t *testing.T, account string, setFlags func(*flag.FlagSet)) (string, error) {
+	if os.Getenv("TEST_JMAP_TOKEN") == "" {
+		assert.Nil(t, os.Setenv("TEST_JMAP_TOKEN", "secret"))
+	}
+
+	defer func() { assert.Nil(t, os.Unsetenv("TEST_JMAP_TOKEN")) }()
+	separator := "?"
+	if strings.Contains(account, "?") {
+		separator = "&"
+	}
+
+	return runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{account + separator + "tokenEnv=TEST_JMAP_TOKEN"}, "jmap", "doc")
+		setFlags(set)
+	})
+}
+
+// runJMAPWatch runs two checks of the fake server in watch mode with push enabled
+func runJMAPWatch(t *testing.T, serverURL string) (string, string) {
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	assert.Nil(t, os.Setenv("TEST_JMAP_TOKEN", "secret"))
+	defer func() { assert.Nil(t, os.Unsetenv("TEST_JMAP_TOKEN")) }()
+	app, writer, errWriter := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{serverURL + "?tokenEnv=TEST_JMAP_TOKEN"}, "jmap", "doc")
+	set.Bool("watch", true, "doc")
+	set.Bool("push", true, "doc")
+	set.Duration("interval", time.Hour, "doc")
+	assert.Nil(t, command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)))
+	return writer.String(), errWriter.String()
+}
+
+func startFakeJMAPServer(t *testing.T) *fakeJMAPServer {
+	server := &fakeJMAPServer{t: t, unread: map[string][]string{"m1": {"e2", "e1"}, "m2": {}, "m3": {}, "m4": {"e4", "e3", "e0"}}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+func (server *fakeJMAPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/.well-known/jmap", "/jmap/session":
+		writeJSON(server.t, w, map[string]interface{}{
+			"username":        "alice@example.com",
+			"apiUrl":          server.URL + "/api",
+			"eventSourceUrl":  server.URL + "/events?types={types}&closeafter={closeafter}&ping={ping}",
+			"primaryAccounts": map[string]string{"urn:ietf:params:jmap:mail": "a1"},
+		})
+	case "/api":
+		server.serveAPI(w, r)
+	case "/events":
+		assert.Equal(server.t, "Mailbox,Email", r.URL.Query().Get("types"))
+		assert.Equal(server.t, "no", r.URL.Query().Get("closeafter"))
+		server.serveEvents(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (server *fakeJMAPServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Using       []string            `json:"using"`
+		MethodCalls [][]json.RawMessage `json:"methodCalls"`
+	}{}
+	assert.Nil(server.t, json.NewDecoder(r.Body).Decode(&request))
+	assert.Equal(server.t, []string{"urn:ietf:params:jmap:core", "urn:ietf:params:jmap:mail"}, request.Using)
+	method := ""
+	assert.Nil(server.t, json.Unmarshal(request.MethodCalls[0][0], &method))
+	arguments := struct {
+		AccountID string `json:"accountId"`
+		Filter    struct {
+			InMailbox  string `json:"inMailbox"`
+			NotKeyword string `json:"notKeyword"`
+		} `json:"filter"`
+	}{}
+	assert.Nil(server.t, json.Unmarshal(request.MethodCalls[0][1], &arguments))
+	assert.Equal(server.t, "a1", arguments.AccountID)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	var result interface{}
+	switch method {
+	case "Mailbox/get":
+		server.calls = append(server.calls, method)
+		list := []map[string]interface{}{}
+		for _, mailbox := range fakeJMAPMailboxes {
+			unread := len(server.unread[mailbox["id"].(string)])
+			withCounts := map[string]interface{}{"unreadEmails": unread, "unreadThreads": (unread + 1) / 2}
+			for key, value := range mailbox {
+				withCounts[key] = value
+			}
+
+			list = append(list, withCounts)
+		}
+
+		result = map[string]interface{}{"accountId": "a1", "state": "1", "list": list}
+	case "Email/query":
+		server.calls = append(server.calls, method+" "+arguments.Filter.InMailbox)
+		assert.Equal(server.t, "$seen", arguments.Filter.NotKeyword)
+		ids := server.unread[arguments.Filter.InMailbox]
+		if server.queryLimit > 0 && len(ids) > server.queryLimit {
+			ids = ids[:server.queryLimit]
+		}
+
+		result = map[string]interface{}{"accountId": "a1", "ids": ids}
+	default:
+		writeJSON(server.t, w, map[string]interface{}{"methodResponses": []interface{}{[]interface{}{"error", map[string]string{"type": "unknownMethod"}, "0"}}})
+		return
+	}
+
+	writeJSON(server.t, w, map[string]interface{}{"methodResponses": []interface{}{[]interface{}{method, result, "0"}}})
+}
+
+// serveEvents streams a state event whenever pushState is called, until the listener is dropped
+func (server *fakeJMAPServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	events := make(chan string, 10)
+	server.mutex.Lock()
+	server.listeners = append(server.listeners, events)
+	server.mutex.Unlock()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			fmt.Fprint(w, event)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (server *fakeJMAPServer) pushState() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, listener := range server.listeners {
+		listener <- ": ping\n\nevent: state\ndata: {\"@type\":\"StateChange\",\"changed\":{\"a1\":{\"Email\":\"2\"}}}\n\n"
+	}
+}
+
+func (server *fakeJMAPServer) dropListeners() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, listener := range server.listeners {
+		close(listener)
+	}
+
+	server.listeners = nil
+}
+
+// waitForListener waits for the event source to be connected and the first check to finish
+func (server *fakeJMAPServer) waitForListener() {
+	for attempt := 0; attempt < 200; attempt++ {
+		server.mutex.Lock()
+		ready := len(server.listeners) != 0 && len(server.calls) != 0
+		server.mutex.Unlock()
+		if ready {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	server.t.Fatal("the event source was not connected")
+}
+
+func (server *fakeJMAPServer) setUnread(mailbox string, ids []string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.unread[mailbox] = ids
+}
+
+func (server *fakeJMAPServer) getCalls() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string{}, server.calls...)
+}
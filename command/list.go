@@ -0,0 +1,156 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// messageSummary is the metadata of a message shown by list
+type messageSummary struct {
+	ID      string    `json:"id"`
+	Date    time.Time `json:"date"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+	Snippet string    `json:"snippet"`
+	Labels  []string  `json:"labels"`
+}
+
+// listSorts compares two messages for each sort order
+var listSorts = map[string]func(a, b messageSummary) bool{
+	"date":    func(a, b messageSummary) bool { return a.Date.After(b.Date) },
+	"from":    func(a, b messageSummary) bool { return strings.ToLower(a.From) < strings.ToLower(b.From) },
+	"subject": func(a, b messageSummary) bool { return strings.ToLower(a.Subject) < strings.ToLower(b.Subject) },
+}
+
+// CmdList lists the unread messages in the inbox
+func CmdList(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 {
+			return cli.NewExitError("Usage: \"unreadChecker list\"", 1)
+		}
+
+		err := checkFlags(c)
+		if err != nil {
+			return err
+		}
+
+		sortBy := c.String("sort")
+		if sortBy == "" {
+			sortBy = "date"
+		}
+
+		less, ok := listSorts[sortBy]
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("Invalid sort: %s", sortBy), 1)
+		}
+
+		if c.String("format") != "" && c.String("format") != "table" && c.String("format") != "json" {
+			return cli.NewExitError(fmt.Sprintf("Invalid format: %s", c.String("format")), 1)
+		}
+
+		srv, httpClient, err := getService(c, cmdBuilder)
+		if err != nil {
+			return err
+		}
+
+		user := gmailUser(c)
+		ids, err := listUnreadLabels(srv, user, countedLabels(c, defaultLabel), c.Int("limit"))
+		if err != nil {
+			return err
+		}
+
+		summaries := fetchMessageSummaries(c.App.ErrWriter, newMessageFetcher(srv, httpClient, "From", "Subject", "Date"), user, ids)
+
+		sort.SliceStable(summaries, func(i, j int) bool {
+			if c.Bool("reverse") {
+				return less(summaries[j], summaries[i])
+			}
+
+			return less(summaries[i], summaries[j])
+		})
+
+		if c.String("format") == "json" {
+			encoder := json.NewEncoder(c.App.Writer)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(summaries)
+		}
+
+		return writeMessageTable(c.App.Writer, summaries)
+	}
+}
+
+// fetchMessageSummaries fetches the messages in ids from the mailbox of user, reporting any that can't be fetched to errWriter
+func fetchMessageSummaries(errWriter io.Writer, fetcher *messageFetcher, user string, ids []string) []messageSummary {
+	summaries := make([]messageSummary, 0, len(ids))
+	for index, result := range fetcher.fetch(user, ids) {
+		if result.err != nil {
+			fmt.Fprintf(errWriter, "Unable to get message %s. %v\n", ids[index], result.err)
+			continue
+		}
+
+		summaries = append(summaries, newMessageSummary(result.message))
+	}
+
+	return summaries
+}
+
+func newMessageSummary(message *gmail.Message) messageSummary {
+	date := time.Unix(0, message.InternalDate*int64(time.Millisecond))
+	if message.InternalDate == 0 {
+		date, _ = mail.ParseDate(messageHeader(message, "Date"))
+	}
+
+	labels := message.LabelIds
+	if labels == nil {
+		labels = []string{}
+	}
+
+	return messageSummary{
+		ID:      message.Id,
+		Date:    date,
+		From:    messageHeader(message, "From"),
+		Subject: messageHeader(message, "Subject"),
+		Snippet: message.Snippet,
+		Labels:  labels,
+	}
+}
+
+func writeMessageTable(writer io.Writer, summaries []messageSummary) error {
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DATE\tFROM\tSUBJECT\tSNIPPET\tLABELS")
+	for _, summary := range summaries {
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%s\n",
+			summary.Date.Local().Format("2006-01-02 15:04"),
+			truncate(summary.From, 30),
+			truncate(summary.Subject, 50),
+			truncate(summary.Snippet, 50),
+			strings.Join(summary.Labels, ","),
+		)
+	}
+
+	return table.Flush()
+}
+
+// truncate shortens value to at most length runes
+func truncate(value string, length int) string {
+	value = strings.Join(strings.Fields(value), " ")
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+
+	return strings.TrimSpace(string(runes[:length-3])) + "..."
+}
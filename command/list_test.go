@@ -0,0 +1,171 @@
+package command_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+var listTestMessages = map[string]*gmail.Message{
+	"a": newListTestMessage("a", "alice@example.com", "Lunch?", "Are you free for lunch", 1500000000000, "INBOX", "UNREAD"),
+	"b": newListTestMessage("b", "Bob <bob@example.com>", "Build failed", "The build   failed\nagain", 1500003600000, "INBOX", "UNREAD", "CATEGORY_UPDATES"),
+	"c": newListTestMessage("c", "carol@example.com", "A very long subject that goes on and on and on and will need to be cut", "", 1500001800000, "INBOX"),
+}
+
+func TestCmdList(t *testing.T) {
+	output, err := runListCheck(t, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"DATE              FROM                   SUBJECT                                            SNIPPET                 LABELS",
+			listDate(1500003600000) + "  Bob <bob@example.com>  Build failed                                       The build failed again  " +
+				"INBOX,UNREAD,CATEGORY_UPDATES",
+			listDate(1500001800000) + "  carol@example.com      A very long subject that goes on and on and on...                          INBOX",
+			listDate(1500000000000) + "  alice@example.com      Lunch?                                             Are you free for lunch  INBOX,UNREAD",
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdListSortFromReverse(t *testing.T) {
+	output, err := runListCheck(t, func(set *flag.FlagSet) {
+		assert.Nil(t, set.Set("sort", "from"))
+		set.Bool("reverse", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(output))
+	assert.Contains(t, output[1], "carol@example.com")
+	assert.Contains(t, output[2], "Bob <bob@example.com>")
+	assert.Contains(t, output[3], "alice@example.com")
+}
+
+func TestCmdListJSONLimit(t *testing.T) {
+	output, err := runListCheck(t, func(set *flag.FlagSet) {
+		assert.Nil(t, set.Set("format", "json"))
+		assert.Nil(t, set.Set("limit", "1"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"[",
+			"  {",
+			`    "id": "a",`,
+			`    "date": "` + time.Unix(1500000000, 0).Format(time.RFC3339) + `",`,
+			`    "from": "alice@example.com",`,
+			`    "subject": "Lunch?",`,
+			`    "snippet": "Are you free for lunch",`,
+			`    "labels": [`,
+			`      "INBOX",`,
+			`      "UNREAD"`,
+			"    ]",
+			"  }",
+			"]",
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdListMissingMessage(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPISequence(t, [][]string{{"a", "d"}}, listTestMessages)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	assert.Nil(t, command.CmdList(cb)(cli.NewContext(app, set, nil)))
+	assert.Contains(t, writer.String(), "Lunch?")
+	assert.Contains(t, errWriter.String(), "Unable to get message d. googleapi: got HTTP response code 404")
+}
+
+func TestCmdListInboxFailure(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPIInboxFailure(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	assert.EqualError(t, command.CmdList(cb)(cli.NewContext(app, set, nil)), "Unable to check inbox. googleapi: got HTTP response code 500 with body: ")
+}
+
+func TestCmdListUsage(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	assert.Nil(t, set.Parse([]string{"foo"}))
+	assert.EqualError(t, command.CmdList(&runner.Test{})(cli.NewContext(app, set, nil)), `Usage: "unreadChecker list"`)
+}
+
+func TestCmdListInvalidSort(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("sort", "size", "doc")
+	assert.EqualError(t, command.CmdList(&runner.Test{})(cli.NewContext(app, set, nil)), "Invalid sort: size")
+}
+
+func TestCmdListInvalidFormat(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("format", "xml", "doc")
+	assert.EqualError(t, command.CmdList(&runner.Test{})(cli.NewContext(app, set, nil)), "Invalid format: xml")
+}
+
+func TestCmdListGlobalFlags(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPISequence(t, [][]string{{"a"}}, listTestMessages)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, _, globalSet := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set := flag.NewFlagSet("list", 0)
+	assert.Nil(t, command.CmdList(cb)(cli.NewContext(app, set, cli.NewContext(app, globalSet, nil))))
+	assert.Contains(t, writer.String(), "Lunch?")
+}
+
+// runListCheck runs CmdList against the mock API and returns the output lines after the OAuth prompt
+func runListCheck(t *testing.T, setFlags func(*flag.FlagSet)) ([]string, error) {
+	ts := getMockGoogleAPISequence(t, [][]string{{"a", "b", "c"}}, listTestMessages)
+	output, _, err := runGmailCommand(t, command.CmdList, ts, func(set *flag.FlagSet) {
+		set.Int("limit", 50, "doc")
+		set.String("sort", "date", "doc")
+		set.String("format", "table", "doc")
+		setFlags(set)
+	})
+	return strings.Split(output, "\n"), err
+}
+
+func newListTestMessage(id, from, subject, snippet string, internalDate int64, labels ...string) *gmail.Message {
+	message := newTestMessage(id, from, subject)
+	message.Snippet = snippet
+	message.InternalDate = internalDate
+	message.LabelIds = labels
+	return message
+}
+
+func listDate(internalDate int64) string {
+	return time.Unix(internalDate/1000, 0).Format("2006-01-02 15:04")
+}
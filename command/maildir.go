@@ -0,0 +1,198 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maildirProvider is a tree of Maildir folders, such as one synced by mbsync or offlineimap.
+// The folder at the root is INBOX and the others are named by their path, with Maildir++ dots replaced by slashes.
+type maildirProvider struct {
+	root string
+}
+
+// maildirMessage is a message file in a Maildir folder
+type maildirMessage struct {
+	id      string
+	modTime int64
+}
+
+func (account maildirProvider) name() string {
+	return account.root
+}
+
+func (account maildirProvider) folders() ([]string, error) {
+	paths, err := account.folderPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]string, 0, len(paths))
+	for folder := range paths {
+		folders = append(folders, folder)
+	}
+
+	sortFolders(folders)
+	return folders, nil
+}
+
+func (account maildirProvider) countUnread(folder string) (int, error) {
+	messages, err := account.unreadMessages(folder)
+	return len(messages), err
+}
+
+func (account maildirProvider) listUnread(folder string, limit int) ([]string, error) {
+	messages, err := account.unreadMessages(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].modTime != messages[j].modTime {
+			return messages[i].modTime > messages[j].modTime
+		}
+
+		return messages[i].id > messages[j].id
+	})
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.id)
+	}
+
+	return ids, nil
+}
+
+func (account maildirProvider) watch(folder string, changes chan<- struct{}, errWriter io.Writer) func() {
+	paths, err := account.folderPaths()
+	if err != nil {
+		fmt.Fprintf(errWriter, "Unable to watch %s: %v\n", account.root, err)
+		return func() {}
+	}
+
+	dirs := []string{}
+	for name, path := range paths {
+		if folder == "" || name == folder {
+			dirs = append(dirs, filepath.Join(path, "new"), filepath.Join(path, "cur"))
+		}
+	}
+
+	return watchPaths(dirs, changes, errWriter)
+}
+
+// unreadMessages returns the messages in new, which have not been seen by a mail client yet,
+// along with the messages in cur that do not have the S (seen) flag.
+func (account maildirProvider) unreadMessages(folder string) ([]maildirMessage, error) {
+	paths, err := account.folderPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := paths[folder]
+	if !ok {
+		return nil, fmt.Errorf("Unable to check maildir folder %s: no such folder in %s", folder, account.root)
+	}
+
+	messages := []maildirMessage{}
+	for _, subdir := range []string{"new", "cur"} {
+		files, err := ioutil.ReadDir(filepath.Join(path, subdir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Unable to check maildir folder %s: %v", folder, err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+
+			// Flags follow ":2," at the end of the file name
+			id, info := file.Name(), ""
+			if index := strings.LastIndex(id, ":2,"); index != -1 {
+				id, info = id[:index], id[index+3:]
+			}
+
+			if subdir == "cur" && strings.Contains(info, "S") {
+				continue
+			}
+
+			messages = append(messages, maildirMessage{id: id, modTime: file.ModTime().UnixNano()})
+		}
+	}
+
+	return messages, nil
+}
+
+// folderPaths maps folder names to the directories holding them
+func (account maildirProvider) folderPaths() (map[string]string, error) {
+	paths := map[string]string{}
+	err := filepath.Walk(account.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		switch info.Name() {
+		case "cur", "new", "tmp":
+			return filepath.SkipDir
+		}
+
+		if !isMaildir(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(account.root, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		switch {
+		case name == ".":
+			name = defaultLabel
+		case strings.HasPrefix(name, ".") && !strings.Contains(name, "/"):
+			name = strings.Replace(name[1:], ".", "/", -1)
+		}
+
+		paths[name] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read maildir %s: %v", account.root, err)
+	}
+
+	return paths, nil
+}
+
+// isMaildir is whether dir has the cur and new subdirectories of a Maildir folder
+func isMaildir(dir string) bool {
+	for _, subdir := range []string{"cur", "new"} {
+		info, err := os.Stat(filepath.Join(dir, subdir))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortFolders sorts folders by name with the inbox first
+func sortFolders(folders []string) {
+	sort.Slice(folders, func(i, j int) bool {
+		if inbox := strings.EqualFold(folders[i], defaultLabel); inbox != strings.EqualFold(folders[j], defaultLabel) {
+			return inbox
+		}
+
+		return folders[i] < folders[j]
+	})
+}
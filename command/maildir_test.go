@@ -0,0 +1,101 @@
+package command_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckMaildir(t *testing.T) {
+	root := newTestMaildir(t)
+	defer removeFile(t, root)
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{root}, "maildir", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	expected := fmt.Sprintf(
+		`{"total":4,"counts":[{"account":"%[1]s","label":"INBOX","unread":3,"messages":3},{"account":"%[1]s","label":"Archive","unread":0,"messages":0},`+
+			`{"account":"%[1]s","label":"Sent","unread":0,"messages":0},{"account":"%[1]s","label":"Work/Projects","unread":1,"messages":1}]}`+"\n",
+		root,
+	)
+	assert.Equal(t, expected, output)
+}
+
+func TestCmdCheckMaildirMissing(t *testing.T) {
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"/nonexistent"}, "maildir", "doc")
+	})
+	assert.EqualError(t, err, "Unable to read maildir /nonexistent: lstat /nonexistent: no such file or directory")
+}
+
+func TestCmdFoldersMaildir(t *testing.T) {
+	root := newTestMaildir(t)
+	defer removeFile(t, root)
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{root}, "maildir", "doc")
+	assert.Nil(t, command.CmdFolders(&runner.Test{})(cli.NewContext(app, set, nil)))
+	lines := strings.Split(writer.String(), "\n")
+	assert.Equal(t, 6, len(lines))
+	assert.Regexp(t, "^ACCOUNT +FOLDER +UNREAD$", lines[0])
+	assert.Regexp(t, "^"+root+" +INBOX +3$", lines[1])
+	assert.Regexp(t, "^"+root+" +Work/Projects +1$", lines[4])
+}
+
+func TestCmdCheckMaildirPush(t *testing.T) {
+	root := newTestMaildir(t)
+	defer removeFile(t, root)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writeMaildirMessage(t, root, "new", "1004.h.host")
+	}()
+
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	app, writer, errWriter := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{root + "/"}, "maildir", "doc")
+	set.Bool("watch", true, "doc")
+	set.Bool("push", true, "doc")
+	set.Duration("interval", time.Hour, "doc")
+	assert.Nil(t, command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)))
+	assert.Equal(t, "4\n5\n", writer.String())
+	assert.Equal(t, "", errWriter.String())
+}
+
+// newTestMaildir creates a Maildir tree with Maildir++ and nested folders
+func newTestMaildir(t *testing.T) string {
+	root, err := ioutil.TempDir("", "testUnreadCheckerMaildir")
+	assert.Nil(t, err)
+	for _, folder := range []string{"", ".Sent", ".Work.Projects", "Archive"} {
+		for _, subdir := range []string{"cur", "new", "tmp"} {
+			assert.Nil(t, os.MkdirAll(filepath.Join(root, folder, subdir), 0700))
+		}
+	}
+
+	writeMaildirMessage(t, root, "new", "1000.a.host")
+	writeMaildirMessage(t, root, "new", ".hidden")
+	writeMaildirMessage(t, root, "tmp", "1005.i.host")
+	writeMaildirMessage(t, root, "cur", "1001.b.host:2,S")
+	writeMaildirMessage(t, root, "cur", "1002.c.host:2,F")
+	writeMaildirMessage(t, root, "cur", "1003.d.host")
+	writeMaildirMessage(t, root, ".Sent/cur", "2000.e.host:2,S")
+	writeMaildirMessage(t, root, ".Work.Projects/new", "3000.f.host")
+	writeMaildirMessage(t, root, "Archive/cur", "4000.g.host:2,RS")
+	return root
+}
+
+func writeMaildirMessage(t *testing.T, root, dir, name string) {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dir, name), []byte("Subject: test\n\nbody\n"), 0600))
+}
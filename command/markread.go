@@ -0,0 +1,158 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// Stdin allows overriding where confirmations are read from for testing
+var Stdin io.Reader = os.Stdin
+
+const (
+	// markReadBatchSize is the most messages Gmail will modify in one request
+	markReadBatchSize = 1000
+	// markReadPreviewSize is the number of messages shown before marking them as read
+	markReadPreviewSize = 10
+)
+
+// CmdMarkRead marks messages as read
+func CmdMarkRead(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 && c.String("query") != "" {
+			return cli.NewExitError("Usage: \"unreadChecker mark-read [--query QUERY | MESSAGE_ID...]\"", 1)
+		}
+
+		err := checkFlags(c)
+		if err != nil {
+			return err
+		}
+
+		scopes := []string{gmail.GmailModifyScope}
+		if c.Bool("dry-run") {
+			scopes = nil
+		}
+
+		srv, httpClient, err := getService(c, cmdBuilder, scopes...)
+		if err != nil {
+			return err
+		}
+
+		user := gmailUser(c)
+		ids := []string(c.Args())
+		if len(ids) == 0 {
+			ids, err = listMarkReadMessages(srv, user, countedLabels(c, defaultLabel), c.String("query"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(ids) == 0 {
+			fmt.Fprintln(c.App.Writer, "No messages to mark as read")
+			return nil
+		}
+
+		previewMarkRead(c, newMessageFetcher(srv, httpClient, "From", "Subject", "Date"), user, ids)
+		messages := pluralMessages(len(ids))
+		if user != defaultUser {
+			messages = fmt.Sprintf("%s in %s", messages, user)
+		}
+
+		if c.Bool("dry-run") {
+			fmt.Fprintf(c.App.Writer, "Would mark %s as read\n", messages)
+			return nil
+		}
+
+		if !c.Bool("yes") && !confirm(c.App.Writer, fmt.Sprintf("Mark %s as read?", messages)) {
+			return cli.NewExitError("Aborted", 1)
+		}
+
+		err = markRead(srv, user, ids)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.App.Writer, "Marked %s as read\n", messages)
+		return nil
+	}
+}
+
+// listMarkReadMessages returns the unread messages in the mailbox of user matching query,
+// or the unread messages that are counted under labels if there is no query
+func listMarkReadMessages(srv *gmail.Service, user string, labels []string, query string) ([]string, error) {
+	if query == "" {
+		return listUnreadLabels(srv, user, labels, 0)
+	}
+
+	call := srv.Users.Messages.List(user).Q(fmt.Sprintf("%s (%s)", unreadQuery, query))
+	resp, err := call.Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search messages. %v", err)
+	}
+
+	ids := appendMessageIDs([]string{}, resp.Messages)
+	for resp.NextPageToken != "" {
+		resp, err = call.PageToken(resp.NextPageToken).Do()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to search messages. %v", err)
+		}
+
+		ids = appendMessageIDs(ids, resp.Messages)
+	}
+
+	return ids, nil
+}
+
+// previewMarkRead shows the first few messages that are going to be marked as read
+func previewMarkRead(c *cli.Context, fetcher *messageFetcher, user string, ids []string) {
+	preview := ids
+	if len(preview) > markReadPreviewSize {
+		preview = preview[:markReadPreviewSize]
+	}
+
+	_ = writeMessageTable(c.App.Writer, fetchMessageSummaries(c.App.ErrWriter, fetcher, user, preview))
+	if len(ids) > len(preview) {
+		fmt.Fprintf(c.App.Writer, "... and %d more\n", len(ids)-len(preview))
+	}
+}
+
+// markRead removes the UNREAD label from messages in batches
+func markRead(srv *gmail.Service, user string, ids []string) error {
+	for start := 0; start < len(ids); start += markReadBatchSize {
+		end := start + markReadBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		request := &gmail.BatchModifyMessagesRequest{Ids: ids[start:end], RemoveLabelIds: []string{"UNREAD"}}
+		err := srv.Users.Messages.BatchModify(user, request).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to mark messages as read. %v", err)
+		}
+	}
+
+	return nil
+}
+
+// confirm asks a yes or no question, defaulting to no
+func confirm(writer io.Writer, question string) bool {
+	fmt.Fprintf(writer, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func pluralMessages(count int) string {
+	if count == 1 {
+		return "1 message"
+	}
+
+	return fmt.Sprintf("%d messages", count)
+}
@@ -0,0 +1,186 @@
+package command_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+const (
+	readonlyScope = "https://www.googleapis.com/auth/gmail.readonly"
+	modifyScope   = "https://www.googleapis.com/auth/gmail.modify"
+)
+
+func TestCmdMarkRead(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(set *flag.FlagSet) {
+		set.Bool("yes", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "Lunch?")
+	assert.Contains(t, output, "Build failed")
+	assert.True(t, strings.HasSuffix(output, "\nMarked 3 messages as read\n"))
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"a", "b", "c"}, RemoveLabelIds: []string{"UNREAD"}}}, mock.modified)
+	assert.Equal(t, []string{readonlyScope + " " + modifyScope + " true"}, mock.authScopes)
+}
+
+func TestCmdMarkReadPrimary(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"e", "d", "c", "b", "a"}}, messages: categoryTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(set *flag.FlagSet) {
+		set.Bool("primary", true, "doc")
+		set.Bool("yes", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(output, "\nMarked 2 messages as read\n"))
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"b", "a"}, RemoveLabelIds: []string{"UNREAD"}}}, mock.modified)
+}
+
+func TestCmdMarkReadConfirm(t *testing.T) {
+	command.Stdin = strings.NewReader("y\n")
+	defer func() { command.Stdin = os.Stdin }()
+	mock := &mockGmail{t: t, checks: [][]string{{"a"}}, messages: listTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "Mark 1 message as read? [y/N] Marked 1 message as read\n")
+	assert.Equal(t, 1, len(mock.modified))
+}
+
+func TestCmdMarkReadDeclined(t *testing.T) {
+	command.Stdin = strings.NewReader("\n")
+	defer func() { command.Stdin = os.Stdin }()
+	mock := &mockGmail{t: t, checks: [][]string{{"a"}}, messages: listTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(*flag.FlagSet) {})
+	assert.EqualError(t, err, "Aborted")
+	assert.True(t, strings.HasSuffix(output, "Mark 1 message as read? [y/N] "))
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest(nil), mock.modified)
+}
+
+func TestCmdMarkReadDryRun(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(set *flag.FlagSet) {
+		set.Bool("dry-run", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "Lunch?")
+	assert.True(t, strings.HasSuffix(output, "\nWould mark 3 messages as read\n"))
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest(nil), mock.modified)
+	assert.Equal(t, []string{readonlyScope + " "}, mock.authScopes)
+}
+
+func TestCmdMarkReadQuery(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b"}}, messages: listTestMessages}
+	_, _, err := runMarkRead(t, mock, nil, func(set *flag.FlagSet) {
+		set.String("query", "from:bob@example.com", "doc")
+		set.Bool("yes", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"label:unread (from:bob@example.com)"}, mock.queries)
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"b"}, RemoveLabelIds: []string{"UNREAD"}}}, mock.modified)
+}
+
+func TestCmdMarkReadIDs(t *testing.T) {
+	ids := []string{}
+	for index := 0; index < 2500; index++ {
+		ids = append(ids, fmt.Sprintf("m%d", index))
+	}
+
+	mock := &mockGmail{t: t, checks: [][]string{{}}, messages: map[string]*gmail.Message{}}
+	output, errOutput, err := runMarkRead(t, mock, ids, func(set *flag.FlagSet) {
+		set.Bool("yes", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, output, "... and 2490 more\n")
+	assert.Contains(t, errOutput, "Unable to get message m0.")
+	assert.Equal(t, 3, len(mock.modified))
+	assert.Equal(t, ids[:1000], mock.modified[0].Ids)
+	assert.Equal(t, ids[1000:2000], mock.modified[1].Ids)
+	assert.Equal(t, ids[2000:], mock.modified[2].Ids)
+	assert.Equal(t, []string(nil), mock.queries)
+}
+
+func TestCmdMarkReadNothingUnread(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{}}}
+	output, _, err := runMarkRead(t, mock, nil, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "No messages to mark as read\n", output)
+}
+
+func TestCmdMarkReadIncrementalConsent(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	mock := &mockGmail{t: t, checks: [][]string{{"a"}}, messages: listTestMessages}
+	ts := mock.start()
+	defer ts.Close()
+	command.BasePath = ts.URL
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	tokenFile := filepath.Join(testFolder, "tokenFile")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(`{"access_token":"fakeToken","expiry":"0001-01-01T00:00:00Z"}`), 0600))
+
+	// A read only token is enough to check
+	cb := &runner.Test{}
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []error(nil), cb.Errors)
+
+	// Marking messages as read asks for the modify scope
+	cb = &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	set.Bool("yes", true, "doc")
+	assert.Nil(t, command.CmdMarkRead(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	contents, err := ioutil.ReadFile(tokenFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(contents), `"scopes":["`+readonlyScope+`","`+modifyScope+`"]`)
+
+	// The new token is used for both from now on
+	cb = &runner.Test{}
+	assert.Nil(t, command.CmdMarkRead(cb)(cli.NewContext(app, set, nil)))
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, 2, len(mock.modified))
+}
+
+func TestCmdMarkReadUsage(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("query", "from:bob@example.com", "doc")
+	assert.Nil(t, set.Parse([]string{"a"}))
+	assert.EqualError(
+		t,
+		command.CmdMarkRead(&runner.Test{})(cli.NewContext(app, set, nil)),
+		`Usage: "unreadChecker mark-read [--query QUERY | MESSAGE_ID...]"`,
+	)
+}
+
+// runMarkRead runs CmdMarkRead against mock and returns the output after the OAuth prompt along with the error output
+func runMarkRead(t *testing.T, mock *mockGmail, args []string, setFlags func(*flag.FlagSet)) (string, string, error) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := mock.start()
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	setFlags(set)
+	assert.Nil(t, set.Parse(args))
+	err := command.CmdMarkRead(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	output := writer.String()
+	assert.Contains(t, output, "Attempting to open")
+	return output[strings.Index(output, "\n")+1:], errWriter.String(), err
+}
@@ -0,0 +1,201 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mboxProvider is an mbox file, or a directory of them where each file is a folder
+type mboxProvider struct {
+	path string
+}
+
+func (account mboxProvider) name() string {
+	return account.path
+}
+
+func (account mboxProvider) folders() ([]string, error) {
+	paths, err := account.folderPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]string, 0, len(paths))
+	for folder := range paths {
+		folders = append(folders, folder)
+	}
+
+	sortFolders(folders)
+	return folders, nil
+}
+
+func (account mboxProvider) countUnread(folder string) (int, error) {
+	ids, err := account.listUnread(folder, 0)
+	return len(ids), err
+}
+
+// listUnread returns the Message-IDs of the unread messages, or their positions in the file if they have none.
+// Messages are appended to mbox files, so the newest are last.
+func (account mboxProvider) listUnread(folder string, limit int) ([]string, error) {
+	paths, err := account.folderPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := paths[folder]
+	if !ok {
+		return nil, fmt.Errorf("Unable to check mbox folder %s: no such folder in %s", folder, account.path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check mbox folder %s: %v", folder, err)
+	}
+
+	defer func() { _ = file.Close() }()
+	unread, err := readMboxUnread(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check mbox folder %s: %v", folder, err)
+	}
+
+	ids := make([]string, 0, len(unread))
+	for index := len(unread) - 1; index >= 0 && (limit <= 0 || len(ids) < limit); index-- {
+		ids = append(ids, unread[index])
+	}
+
+	return ids, nil
+}
+
+func (account mboxProvider) watch(folder string, changes chan<- struct{}, errWriter io.Writer) func() {
+	paths, err := account.folderPaths()
+	if err != nil {
+		fmt.Fprintf(errWriter, "Unable to watch %s: %v\n", account.path, err)
+		return func() {}
+	}
+
+	// Mail clients often replace mbox files instead of writing to them, so the directories are watched
+	dirs := []string{}
+	for name, path := range paths {
+		if (folder == "" || name == folder) && !hasString(dirs, filepath.Dir(path)) {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+
+	return watchPaths(dirs, changes, errWriter)
+}
+
+// folderPaths maps folder names to mbox files.  A single file is named after itself.
+func (account mboxProvider) folderPaths() (map[string]string, error) {
+	info, err := os.Stat(account.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read mbox %s: %v", account.path, err)
+	}
+
+	if !info.IsDir() {
+		return map[string]string{filepath.Base(account.path): account.path}, nil
+	}
+
+	paths := map[string]string{}
+	err = filepath.Walk(account.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != account.path {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() || !isMbox(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(account.path, path)
+		if err != nil {
+			return err
+		}
+
+		paths[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read mbox %s: %v", account.path, err)
+	}
+
+	return paths, nil
+}
+
+// isMbox is whether path is empty or starts with a From line
+func isMbox(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer func() { _ = file.Close() }()
+	start := make([]byte, 5)
+	n, _ := io.ReadFull(file, start)
+	return n == 0 || string(start[:n]) == "From "
+}
+
+// readMboxUnread returns the ids of the messages in an mbox that have not been read, oldest first.
+// A message has been read when its Status header contains R, and is skipped when its X-Status header marks it deleted.
+func readMboxUnread(reader io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	unread := []string{}
+	count := 0
+	inHeaders := false
+	previousBlank := true
+	var id, status, xStatus string
+	finish := func() {
+		if count == 0 || strings.Contains(status, "R") || strings.Contains(xStatus, "D") {
+			return
+		}
+
+		if id == "" {
+			id = fmt.Sprintf("%d", count)
+		}
+
+		unread = append(unread, id)
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case previousBlank && strings.HasPrefix(line, "From "):
+			finish()
+			count++
+			inHeaders = true
+			id, status, xStatus = "", "", ""
+		case inHeaders && line == "":
+			inHeaders = false
+		case inHeaders:
+			name, value := line, ""
+			if index := strings.Index(line, ":"); index != -1 {
+				name, value = line[:index], strings.TrimSpace(line[index+1:])
+			}
+
+			switch strings.ToLower(name) {
+			case "message-id":
+				id = strings.Trim(value, "<>")
+			case "status":
+				status = value
+			case "x-status":
+				xStatus = value
+			}
+		}
+
+		previousBlank = line == ""
+	}
+
+	finish()
+	return unread, scanner.Err()
+}
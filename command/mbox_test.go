@@ -0,0 +1,111 @@
+package command_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+const testMbox = `From alice@example.com Mon Jul 10 09:00:00 2017
+From: alice@example.com
+Message-ID: <1@example.com>
+Status: RO
+
+Read already
+From here on this is still the body
+
+From bob@example.com Mon Jul 10 10:00:00 2017
+From: bob@example.com
+Message-ID: <2@example.com>
+Status: O
+
+Seen by the client but not read
+
+From carol@example.com Mon Jul 10 11:00:00 2017
+From: carol@example.com
+
+New and without an id
+
+From dave@example.com Mon Jul 10 12:00:00 2017
+From: dave@example.com
+Message-ID: <4@example.com>
+X-Status: D
+
+Deleted
+`
+
+func TestCmdCheckMbox(t *testing.T) {
+	dir := newTestMboxDir(t)
+	defer removeFile(t, dir)
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{filepath.Join(dir, "inbox")}, "mbox", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf(`{"total":2,"counts":[{"account":"%s/inbox","label":"inbox","unread":2,"messages":2}]}`+"\n", dir), output)
+}
+
+func TestCmdCheckMboxDirectory(t *testing.T) {
+	dir := newTestMboxDir(t)
+	defer removeFile(t, dir)
+	output, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{dir}, "mbox", "doc")
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		fmt.Sprintf(
+			`{"total":3,"counts":[{"account":"%[1]s","label":"inbox","unread":2,"messages":2},`+
+				`{"account":"%[1]s","label":"lists/go","unread":1,"messages":1},{"account":"%[1]s","label":"sent","unread":0,"messages":0}]}`+"\n",
+			dir,
+		),
+		output,
+	)
+}
+
+func TestCmdCheckMboxMissing(t *testing.T) {
+	_, err := runIMAPCheck(t, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{"/nonexistent"}, "mbox", "doc")
+	})
+	assert.EqualError(t, err, "Unable to read mbox /nonexistent: stat /nonexistent: no such file or directory")
+}
+
+func TestCmdFoldersMbox(t *testing.T) {
+	dir := newTestMboxDir(t)
+	defer removeFile(t, dir)
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.Var(&cli.StringSlice{filepath.Join(dir, "lists")}, "mbox", "doc")
+	assert.Nil(t, command.CmdFolders(&runner.Test{})(cli.NewContext(app, set, nil)))
+	assert.Regexp(t, "^ACCOUNT +FOLDER +UNREAD\n"+filepath.Join(dir, "lists")+" +go +1\n$", writer.String())
+}
+
+// newTestMboxDir creates a directory of mbox files along with files that should be skipped
+func newTestMboxDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "testUnreadCheckerMbox")
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "lists"), 0700))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, ".cache"), 0700))
+	files := map[string]string{
+		"inbox":         testMbox,
+		"sent":          "",
+		"lists/go":      "From list@example.com Mon Jul 10 09:00:00 2017\nSubject: go\n\nhi\n",
+		"notes.txt":     "Not a mailbox\n",
+		".cache/inbox":  testMbox,
+		"lists/.hidden": testMbox,
+	}
+	for name, contents := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600))
+	}
+
+	return dir
+}
@@ -0,0 +1,273 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttDisconnect = 0xE0
+	mqttRetain     = 0x01
+)
+
+var mqttTopicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// mqttObjectIDInvalid matches the characters Home Assistant does not allow in a discovery object id
+var mqttObjectIDInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttPublisher publishes counts to an MQTT broker as retained messages.
+// It connects for each set of results so that there is no connection to keep alive between checks.
+type mqttPublisher struct {
+	broker          *url.URL
+	username        string
+	password        string
+	topicPrefix     string
+	discovery       bool
+	discoveryPrefix string
+	tlsConfig       *tls.Config
+	discovered      map[string]bool
+}
+
+// homeAssistantSensor is a Home Assistant MQTT discovery config message
+type homeAssistantSensor struct {
+	Name              string `json:"name"`
+	UniqueID          string `json:"unique_id"`
+	StateTopic        string `json:"state_topic"`
+	UnitOfMeasurement string `json:"unit_of_measurement"`
+	Icon              string `json:"icon"`
+}
+
+func newMQTTPublisher(c *cli.Context) (*mqttPublisher, error) {
+	if c.String("mqttBroker") == "" {
+		return nil, nil
+	}
+
+	broker, err := url.Parse(c.String("mqttBroker"))
+	if err != nil || broker.Host == "" {
+		return nil, cli.NewExitError(fmt.Sprintf("Invalid mqttBroker: %s", c.String("mqttBroker")), 1)
+	}
+
+	if c.String("mqttPassword") != "" && c.String("mqttUsername") == "" {
+		return nil, cli.NewExitError("You must specify an mqttUsername to use an mqttPassword", 1)
+	}
+
+	publisher := &mqttPublisher{
+		broker:          broker,
+		username:        c.String("mqttUsername"),
+		password:        c.String("mqttPassword"),
+		topicPrefix:     strings.TrimSuffix(c.String("mqttTopicPrefix"), "/"),
+		discovery:       c.Bool("mqttDiscovery"),
+		discoveryPrefix: strings.TrimSuffix(c.String("mqttDiscoveryPrefix"), "/"),
+		discovered:      map[string]bool{},
+	}
+
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		publisher.tlsConfig = &tls.Config{ServerName: broker.Hostname()}
+		if c.String("mqttCAFile") != "" {
+			publisher.tlsConfig.RootCAs, err = loadRootCAs(c.String("mqttCAFile"), "mqttCAFile")
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, cli.NewExitError(fmt.Sprintf("Invalid mqttBroker scheme: %s", broker.Scheme), 1)
+	}
+
+	return publisher, nil
+}
+
+// publish connects to the broker and publishes a retained state message for every count
+func (publisher *mqttPublisher) publish(counts []unreadCount) error {
+	conn, err := publisher.connect()
+	if err != nil {
+		return fmt.Errorf("Unable to connect to MQTT broker: %v", err)
+	}
+
+	defer func() { _ = conn.Close() }()
+	writer := bufio.NewWriter(conn)
+	for _, count := range counts {
+		stateTopic := publisher.stateTopic(count)
+		if publisher.discovery && !publisher.discovered[stateTopic] {
+			uniqueID := mqttObjectIDInvalid.ReplaceAllString(fmt.Sprintf("%s_%s_%s", Name, count.Account, count.Label), "_")
+			config, _ := json.Marshal(homeAssistantSensor{
+				Name:              fmt.Sprintf("Unread %s %s", count.Account, count.Label),
+				UniqueID:          uniqueID,
+				StateTopic:        stateTopic,
+				UnitOfMeasurement: "messages",
+				Icon:              "mdi:email",
+			})
+			writeMQTTPublish(writer, fmt.Sprintf("%s/sensor/%s/config", publisher.discoveryPrefix, uniqueID), config)
+		}
+
+		writeMQTTPublish(writer, stateTopic, []byte(fmt.Sprintf("%d", count.Unread)))
+	}
+
+	writeMQTTPacket(writer, mqttDisconnect, nil)
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to publish to MQTT broker: %v", err)
+	}
+
+	if publisher.discovery {
+		for _, count := range counts {
+			publisher.discovered[publisher.stateTopic(count)] = true
+		}
+	}
+
+	return nil
+}
+
+func (publisher *mqttPublisher) stateTopic(count unreadCount) string {
+	return fmt.Sprintf("%s/%s/%s", publisher.topicPrefix, mqttTopicEscaper.Replace(count.Account), mqttTopicEscaper.Replace(count.Label))
+}
+
+// connect opens a connection to the broker and waits for it to accept the CONNECT packet
+func (publisher *mqttPublisher) connect() (net.Conn, error) {
+	host := publisher.broker.Host
+	if publisher.broker.Port() == "" {
+		port := "1883"
+		if publisher.tlsConfig != nil {
+			port = "8883"
+		}
+
+		host = net.JoinHostPort(publisher.broker.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if publisher.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, publisher.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	var connect bytes.Buffer
+	writeMQTTString(&connect, "MQTT")
+	flags := byte(0x02)
+	if publisher.username != "" {
+		flags |= 0x80
+	}
+
+	if publisher.password != "" {
+		flags |= 0x40
+	}
+
+	connect.Write([]byte{4, flags, 0, 60})
+	writeMQTTString(&connect, fmt.Sprintf("%s-%d", Name, os.Getpid()))
+	if publisher.username != "" {
+		writeMQTTString(&connect, publisher.username)
+	}
+
+	if publisher.password != "" {
+		writeMQTTString(&connect, publisher.password)
+	}
+
+	writer := bufio.NewWriter(conn)
+	writeMQTTPacket(writer, mqttConnect, connect.Bytes())
+	err = writer.Flush()
+	if err == nil {
+		err = readMQTTConnAck(conn)
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func readMQTTConnAck(reader io.Reader) error {
+	connAck := make([]byte, 4)
+	_, err := io.ReadFull(reader, connAck)
+	if err != nil {
+		return err
+	}
+
+	if connAck[0] != mqttConnAck || connAck[1] != 2 {
+		return fmt.Errorf("unexpected response to CONNECT")
+	}
+
+	switch connAck[3] {
+	case 0:
+		return nil
+	case 4, 5:
+		return fmt.Errorf("not authorized")
+	}
+
+	return fmt.Errorf("connection refused with code %d", connAck[3])
+}
+
+func writeMQTTPublish(writer io.Writer, topic string, payload []byte) {
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+	writeMQTTPacket(writer, mqttPublish|mqttRetain, body.Bytes())
+}
+
+// writeMQTTPacket writes a fixed header followed by body
+func writeMQTTPacket(writer io.Writer, packetType byte, body []byte) {
+	header := []byte{packetType}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+
+		header = append(header, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	_, _ = writer.Write(header)
+	_, _ = writer.Write(body)
+}
+
+func writeMQTTString(buffer *bytes.Buffer, value string) {
+	_ = binary.Write(buffer, binary.BigEndian, uint16(len(value)))
+	buffer.WriteString(value)
+}
+
+// loadRootCAs reads the certificate authorities in caFile.  flag names the setting in errors.
+func loadRootCAs(caFile, flag string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", flag, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("Unable to parse %s: no certificates found", flag)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,280 @@
+package command_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// fakeMQTTBroker records the CONNECT and PUBLISH packets it receives
+type fakeMQTTBroker struct {
+	listener   net.Listener
+	returnCode byte
+	mutex      sync.Mutex
+	connects   []string
+	publishes  []string
+}
+
+func TestCmdCheckMQTT(t *testing.T) {
+	broker := startFakeMQTTBroker(t, nil, 0)
+	defer broker.close()
+	output, _, err := runMQTTCheck(t, false, func(set *flag.FlagSet) {
+		set.String("mqttBroker", "tcp://"+broker.listener.Addr().String(), "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4\n", output)
+	assert.Equal(t, []string{"MQTT 4 clean"}, broker.getConnects())
+	assert.Equal(t, []string{"retain unreadChecker/me@example.com/INBOX 4"}, broker.getPublishes())
+}
+
+func TestCmdCheckMQTTDiscovery(t *testing.T) {
+	broker := startFakeMQTTBroker(t, nil, 0)
+	defer broker.close()
+	_, _, err := runMQTTCheck(t, true, func(set *flag.FlagSet) {
+		set.String("mqttBroker", "mqtt://"+broker.listener.Addr().String(), "doc")
+		set.String("mqttUsername", "user", "doc")
+		set.String("mqttPassword", "pass", "doc")
+		set.Bool("mqttDiscovery", true, "doc")
+		assert.Nil(t, set.Set("mqttTopicPrefix", "mail/"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"MQTT 4 clean user pass", "MQTT 4 clean user pass"}, broker.getConnects())
+	assert.Equal(
+		t,
+		[]string{
+			`retain homeassistant/sensor/unreadChecker_me_example_com_INBOX/config ` +
+				`{"name":"Unread me@example.com INBOX","unique_id":"unreadChecker_me_example_com_INBOX",` +
+				`"state_topic":"mail/me@example.com/INBOX","unit_of_measurement":"messages","icon":"mdi:email"}`,
+			"retain mail/me@example.com/INBOX 4",
+			"retain mail/me@example.com/INBOX 4",
+		},
+		broker.getPublishes(),
+	)
+}
+
+func TestCmdCheckMQTTNotAuthorized(t *testing.T) {
+	broker := startFakeMQTTBroker(t, nil, 5)
+	defer broker.close()
+	output, errOutput, err := runMQTTCheck(t, false, func(set *flag.FlagSet) {
+		set.String("mqttBroker", "tcp://"+broker.listener.Addr().String(), "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4\n", output)
+	assert.Equal(t, "Unable to connect to MQTT broker: not authorized\n", errOutput)
+	assert.Equal(t, []string(nil), broker.getPublishes())
+}
+
+func TestCmdCheckMQTTUnreachable(t *testing.T) {
+	broker := startFakeMQTTBroker(t, nil, 0)
+	broker.close()
+	output, errOutput, err := runMQTTCheck(t, false, func(set *flag.FlagSet) {
+		set.String("mqttBroker", "tcp://"+broker.listener.Addr().String(), "doc")
+		set.String("format", "i3blocks", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4\n4\n\n", output)
+	assert.Contains(t, errOutput, "Unable to connect to MQTT broker: ")
+}
+
+func TestCmdCheckMQTTTLS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	broker := startFakeMQTTBroker(t, tlsServer.TLS, 0)
+	defer broker.close()
+	caFile := filepath.Join(os.TempDir(), "testUnreadCheckerCA.pem")
+	defer removeFile(t, caFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0600))
+	output, _, err := runMQTTCheck(t, false, func(set *flag.FlagSet) {
+		set.String("mqttBroker", "mqtts://"+broker.listener.Addr().String(), "doc")
+		set.String("mqttCAFile", caFile, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4\n", output)
+	assert.Equal(t, []string{"retain unreadChecker/me@example.com/INBOX 4"}, broker.getPublishes())
+}
+
+func TestCmdCheckMQTTInvalidBroker(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("mqttBroker", "http://localhost", "doc")
+	cb := &runner.Test{}
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "Invalid mqttBroker scheme: http")
+}
+
+func TestCmdCheckMQTTPasswordWithoutUsername(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("mqttBroker", "tcp://localhost", "doc")
+	set.String("mqttPassword", "pass", "doc")
+	cb := &runner.Test{}
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "You must specify an mqttUsername to use an mqttPassword")
+}
+
+// runMQTTCheck runs CmdCheck against the mock API and returns the output after the OAuth prompt
+func runMQTTCheck(t *testing.T, watch bool, setFlags func(*flag.FlagSet)) (string, string, error) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.String("mqttTopicPrefix", "unreadChecker", "doc")
+	set.String("mqttDiscoveryPrefix", "homeassistant", "doc")
+	if watch {
+		command.MaxWatchIterations = 2
+		defer func() { command.MaxWatchIterations = 0 }()
+		set.Bool("watch", true, "doc")
+		set.Duration("interval", time.Millisecond, "doc")
+	}
+
+	setFlags(set)
+	err := command.CmdCheck(cb)(cli.NewContext(app, set, nil))
+	output := writer.String()
+	return output[strings.Index(output, "\n")+1:], errWriter.String(), err
+}
+
+func startFakeMQTTBroker(t *testing.T, tlsConfig *tls.Config, returnCode byte) *fakeMQTTBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	broker := &fakeMQTTBroker{listener: listener, returnCode: returnCode}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			broker.handle(t, conn)
+		}
+	}()
+
+	return broker
+}
+
+func (broker *fakeMQTTBroker) handle(t *testing.T, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		packetType, body, err := readFakeMQTTPacket(reader)
+		if err != nil {
+			return
+		}
+
+		broker.mutex.Lock()
+		switch packetType & 0xF0 {
+		case 0x10:
+			broker.connects = append(broker.connects, parseFakeMQTTConnect(body))
+			_, err = conn.Write([]byte{0x20, 2, 0, broker.returnCode})
+			assert.Nil(t, err)
+		case 0x30:
+			topicLength := binary.BigEndian.Uint16(body)
+			retain := ""
+			if packetType&0x01 != 0 {
+				retain = "retain "
+			}
+
+			broker.publishes = append(broker.publishes, retain+string(body[2:2+topicLength])+" "+string(body[2+topicLength:]))
+		case 0xE0:
+			broker.mutex.Unlock()
+			return
+		}
+		broker.mutex.Unlock()
+	}
+}
+
+func readFakeMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	packetType, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	for multiplier := 1; ; multiplier *= 128 {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return packetType, body, err
+}
+
+// parseFakeMQTTConnect summarizes a CONNECT packet as "PROTOCOL LEVEL [clean] [USERNAME] [PASSWORD]"
+func parseFakeMQTTConnect(body []byte) string {
+	readString := func() string {
+		length := binary.BigEndian.Uint16(body)
+		value := string(body[2 : 2+length])
+		body = body[2+length:]
+		return value
+	}
+
+	summary := []string{readString(), string(rune('0' + body[0]))}
+	flags := body[1]
+	body = body[4:]
+	if flags&0x02 != 0 {
+		summary = append(summary, "clean")
+	}
+
+	readString()
+	if flags&0x80 != 0 {
+		summary = append(summary, readString())
+	}
+
+	if flags&0x40 != 0 {
+		summary = append(summary, readString())
+	}
+
+	return strings.Join(summary, " ")
+}
+
+func (broker *fakeMQTTBroker) getConnects() []string {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.connects
+}
+
+// getPublishes waits briefly for publishes that are still in flight
+func (broker *fakeMQTTBroker) getPublishes() []string {
+	time.Sleep(10 * time.Millisecond)
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.publishes
+}
+
+func (broker *fakeMQTTBroker) close() {
+	_ = broker.listener.Close()
+}
@@ -0,0 +1,192 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+var notifyEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// notifier sends desktop notifications when new mail arrives
+type notifier struct {
+	cmdBuilder  runner.Builder
+	maxMessages int
+	interval    time.Duration
+	quietHours  *timeWindow
+	lastSent    time.Time
+}
+
+// timeWindow is a daily window of time in minutes after midnight.  The window may wrap past midnight.
+type timeWindow struct {
+	start int
+	end   int
+}
+
+func newNotifier(c *cli.Context, cmdBuilder runner.Builder) (*notifier, error) {
+	if !c.Bool("notify") {
+		return nil, nil
+	}
+
+	if c.Int("notifyMax") < 0 {
+		return nil, cli.NewExitError(fmt.Sprintf("Invalid notifyMax: %d can not be negative", c.Int("notifyMax")), 1)
+	}
+
+	notifier := &notifier{
+		cmdBuilder:  cmdBuilder,
+		maxMessages: c.Int("notifyMax"),
+		interval:    c.Duration("notifyInterval"),
+	}
+
+	if c.String("quietHours") != "" {
+		window, err := parseTimeWindow(c.String("quietHours"))
+		if err != nil {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid quietHours: %v", err), 1)
+		}
+
+		notifier.quietHours = window
+	}
+
+	return notifier, nil
+}
+
+// notify sends a notification if any counts have increased since the previous check.
+// New messages in the Gmail accounts are described in the notification.
+// Messages whose metadata can not be fetched are left out of the notification and reported as an error.
+func (notifier *notifier) notify(accounts []gmailProvider, previous, current []unreadCount) error {
+	increase, newMessages := findNewMessages(previous, current)
+	if increase <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if notifier.quietHours != nil && notifier.quietHours.contains(now) {
+		return nil
+	}
+
+	if !notifier.lastSent.IsZero() && now.Sub(notifier.lastSent) < notifier.interval {
+		return nil
+	}
+
+	newMessages = gmailMessages(accounts, newMessages)
+	if len(newMessages) > notifier.maxMessages {
+		newMessages = newMessages[:notifier.maxMessages]
+	}
+
+	lines := make([]string, 0, len(newMessages))
+	var fetchErr error
+	for index, result := range fetchRefs(accounts, newMessages) {
+		if result.err != nil {
+			if fetchErr == nil {
+				fetchErr = fmt.Errorf("Unable to get message %s. %v", newMessages[index].ID, result.err)
+			}
+
+			continue
+		}
+
+		lines = append(lines, notifyEscaper.Replace(fmt.Sprintf("%s: %s", messageHeader(result.message, "From"), messageHeader(result.message, "Subject"))))
+	}
+
+	summary := "1 new message"
+	if increase != 1 {
+		summary = fmt.Sprintf("%d new messages", increase)
+	}
+
+	notifier.lastSent = now
+	cmd := notifier.cmdBuilder.New("", "notify-send", "--app-name="+Name, "--icon=mail-unread", summary, strings.Join(lines, "\n"))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Unable to send notification: %v %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return fetchErr
+}
+
+// messageRef identifies a message in an account
+type messageRef struct {
+	Account string
+	ID      string
+}
+
+// findNewMessages returns how many more unread messages there are than in the previous check,
+// along with the messages that were not unread in the previous check, newest first.
+func findNewMessages(previous, current []unreadCount) (int, []messageRef) {
+	increase := 0
+	newMessages := []messageRef{}
+	for _, count := range current {
+		previousCount := findCount(previous, count.Account, count.Label)
+		if previousCount == nil {
+			continue
+		}
+
+		increase += count.Messages - previousCount.Messages
+		for _, id := range newMessageIDs(*previousCount, count) {
+			newMessages = append(newMessages, messageRef{Account: count.Account, ID: id})
+		}
+	}
+
+	return increase, newMessages
+}
+
+// gmailMessages returns the refs to messages in the Gmail accounts, which are the only ones that can be described
+func gmailMessages(accounts []gmailProvider, refs []messageRef) []messageRef {
+	messages := []messageRef{}
+	for _, ref := range refs {
+		for _, account := range accounts {
+			if ref.Account == account.name() {
+				messages = append(messages, ref)
+				break
+			}
+		}
+	}
+
+	return messages
+}
+
+func messageHeader(message *gmail.Message, name string) string {
+	if message.Payload == nil {
+		return ""
+	}
+
+	for _, header := range message.Payload.Headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+
+	return ""
+}
+
+// parseTimeWindow parses a window like 22:00-07:00
+func parseTimeWindow(window string) (*timeWindow, error) {
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%s is not in the format HH:MM-HH:MM", window)
+	}
+
+	start, err := time.Parse("15:04", parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in the format HH:MM-HH:MM", window)
+	}
+
+	end, err := time.Parse("15:04", parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in the format HH:MM-HH:MM", window)
+	}
+
+	return &timeWindow{start: start.Hour()*60 + start.Minute(), end: end.Hour()*60 + end.Minute()}, nil
+}
+
+func (window timeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if window.start <= window.end {
+		return minute >= window.start && minute < window.end
+	}
+
+	return minute >= window.start || minute < window.end
+}
@@ -0,0 +1,158 @@
+package command_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+var testMessages = map[string]*gmail.Message{
+	"a": newTestMessage("a", "Alice <alice@example.com>", "First"),
+	"b": newTestMessage("b", "Bob <bob@example.com>", "Second"),
+	"c": newTestMessage("c", "Carol <carol@example.com>", "Third & last"),
+}
+
+func TestCmdCheckNotify(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"c", "b", "a"}},
+		func(*flag.FlagSet) {},
+		runner.NewExpectedCommand(
+			"",
+			"notify-send --app-name=unreadChecker --icon=mail-unread 2 new messages "+
+				"Carol &lt;carol@example.com&gt;: Third &amp; last\nBob &lt;bob@example.com&gt;: Second",
+			"",
+			0,
+		),
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyMax(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"c", "b", "a"}},
+		func(set *flag.FlagSet) {
+			assert.Nil(t, set.Set("notifyMax", "1"))
+		},
+		runner.NewExpectedCommand(
+			"",
+			"notify-send --app-name=unreadChecker --icon=mail-unread 2 new messages Carol &lt;carol@example.com&gt;: Third &amp; last",
+			"",
+			0,
+		),
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyNoIncrease(t *testing.T) {
+	errOutput := runNotifyCheck(t, [][]string{{"b", "a"}, {"c"}, {"c"}}, func(*flag.FlagSet) {})
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyRateLimit(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}, {"c", "b", "a"}},
+		func(set *flag.FlagSet) {
+			assert.Nil(t, set.Set("notifyInterval", "1h"))
+		},
+		runner.NewExpectedCommand("", "notify-send --app-name=unreadChecker --icon=mail-unread 1 new message Bob &lt;bob@example.com&gt;: Second", "", 0),
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyQuietHours(t *testing.T) {
+	now := time.Now()
+	quietHours := fmt.Sprintf("%s-%s", now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		func(set *flag.FlagSet) {
+			set.String("quietHours", quietHours, "doc")
+		},
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyOutsideQuietHours(t *testing.T) {
+	now := time.Now()
+	quietHours := fmt.Sprintf("%s-%s", now.Add(time.Hour).Format("15:04"), now.Add(2*time.Hour).Format("15:04"))
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		func(set *flag.FlagSet) {
+			set.String("quietHours", quietHours, "doc")
+		},
+		runner.NewExpectedCommand("", "notify-send --app-name=unreadChecker --icon=mail-unread 1 new message Bob &lt;bob@example.com&gt;: Second", "", 0),
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdCheckNotifyFailure(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		func(*flag.FlagSet) {},
+		runner.NewExpectedCommand("", "notify-send .*", "no display", 1),
+	)
+	assert.Equal(t, "Unable to send notification: exit status 1 no display\n", errOutput)
+}
+
+func TestCmdCheckNotifyMissingMessage(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"d", "b", "a"}},
+		func(*flag.FlagSet) {},
+		runner.NewExpectedCommand("", "notify-send --app-name=unreadChecker --icon=mail-unread 2 new messages Bob &lt;bob@example.com&gt;: Second", "", 0),
+	)
+	assert.Contains(t, errOutput, "Unable to get message d. googleapi: got HTTP response code 404")
+}
+
+func TestCmdCheckNotifyInvalidQuietHours(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.Bool("notify", true, "doc")
+	set.String("quietHours", "22:00", "doc")
+	cb := &runner.Test{}
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "Invalid quietHours: 22:00 is not in the format HH:MM-HH:MM")
+}
+
+func TestCmdCheckNotifyNegativeMax(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.Bool("notify", true, "doc")
+	set.Int("notifyMax", -1, "doc")
+	cb := &runner.Test{}
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "Invalid notifyMax: -1 can not be negative")
+}
+
+// runNotifyCheck runs one watch iteration per entry in checks with notifications enabled and returns the error output
+func runNotifyCheck(t *testing.T, checks [][]string, setFlags func(*flag.FlagSet), notifications ...*runner.ExpectedCommand) string {
+	command.MaxWatchIterations = len(checks)
+	defer func() { command.MaxWatchIterations = 0 }()
+	_, errOutput, err := runGmailCommand(t, command.CmdCheck, getMockGoogleAPISequence(t, checks, testMessages), func(set *flag.FlagSet) {
+		set.Bool("watch", true, "doc")
+		set.Duration("interval", time.Millisecond, "doc")
+		set.Bool("notify", true, "doc")
+		set.Int("notifyMax", 3, "doc")
+		set.Duration("notifyInterval", 0, "doc")
+		setFlags(set)
+	}, notifications...)
+	assert.Nil(t, err)
+	return errOutput
+}
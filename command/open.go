@@ -0,0 +1,60 @@
+package command
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// gmailWebURL is the address of the Gmail web interface
+const gmailWebURL = "https://mail.google.com/mail/u/%d/#%s"
+
+// CmdOpen opens the unread messages, or a single message, in the Gmail web interface
+func CmdOpen(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() > 1 {
+			return cli.NewExitError("Usage: \"unreadChecker open [MESSAGE_ID]\"", 1)
+		}
+
+		if gmailUser(c) != defaultUser {
+			return cli.NewExitError("open can not show a delegated mailbox, switch to it in Gmail and use --accountIndex instead of --user", 1)
+		}
+
+		if c.Int("accountIndex") < 0 {
+			return cli.NewExitError(fmt.Sprintf("Invalid accountIndex: %d", c.Int("accountIndex")), 1)
+		}
+
+		webURL := fmt.Sprintf(gmailWebURL, c.Int("accountIndex"), "search/"+url.QueryEscape(webQuery(c)))
+		if c.NArg() == 1 {
+			webURL = gmailMessageURL(c.Int("accountIndex"), c.Args().First())
+		}
+
+		fmt.Fprintf(c.App.Writer, "Opening %s\n", webURL)
+		err := openBrowser(cmdBuilder, webURL)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to open browser: %v", err), 1)
+		}
+
+		return nil
+	}
+}
+
+// gmailMessageURL is the address of a message in the Gmail web interface
+func gmailMessageURL(accountIndex int, id string) string {
+	return fmt.Sprintf(gmailWebURL, accountIndex, "all/"+url.PathEscape(id))
+}
+
+// webQuery is the Gmail search for the messages that are counted
+func webQuery(c *cli.Context) string {
+	if query := c.String("query"); query != "" {
+		return query
+	}
+
+	if c.Bool("primary") || c.GlobalBool("primary") {
+		return "in:inbox category:primary is:unread"
+	}
+
+	return "in:inbox is:unread"
+}
@@ -0,0 +1,84 @@
+package command_test
+
+import (
+	"flag"
+	"regexp"
+	"testing"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdOpen(t *testing.T) {
+	output, err := runOpen(t, "https://mail.google.com/mail/u/0/#search/in%3Ainbox+is%3Aunread", 0, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "Opening https://mail.google.com/mail/u/0/#search/in%3Ainbox+is%3Aunread\n", output)
+}
+
+func TestCmdOpenPrimary(t *testing.T) {
+	_, err := runOpen(t, "https://mail.google.com/mail/u/0/#search/in%3Ainbox+category%3Aprimary+is%3Aunread", 0, func(set *flag.FlagSet) {
+		set.Bool("primary", true, "doc")
+	})
+	assert.Nil(t, err)
+}
+
+func TestCmdOpenQueryAccountIndex(t *testing.T) {
+	_, err := runOpen(t, "https://mail.google.com/mail/u/2/#search/from%3Aci%40example.com", 0, func(set *flag.FlagSet) {
+		set.Int("accountIndex", 2, "doc")
+		set.String("query", "from:ci@example.com", "doc")
+	})
+	assert.Nil(t, err)
+}
+
+func TestCmdOpenMessage(t *testing.T) {
+	_, err := runOpen(t, "https://mail.google.com/mail/u/0/#all/15d3a9c2e8f4b7a1", 0, func(set *flag.FlagSet) {
+		assert.Nil(t, set.Parse([]string{"15d3a9c2e8f4b7a1"}))
+	})
+	assert.Nil(t, err)
+}
+
+func TestCmdOpenBrowserFailure(t *testing.T) {
+	_, err := runOpen(t, "https://mail.google.com/mail/u/0/#search/in%3Ainbox+is%3Aunread", 1, func(*flag.FlagSet) {})
+	assert.EqualError(t, err, "Unable to open browser: exit status 1")
+}
+
+func TestCmdOpenUsage(t *testing.T) {
+	set := flag.NewFlagSet("test", 0)
+	assert.Nil(t, set.Parse([]string{"a", "b"}))
+	app, _, _ := appWithTestWriters()
+	assert.EqualError(t, command.CmdOpen(&runner.Test{})(cli.NewContext(app, set, nil)), `Usage: "unreadChecker open [MESSAGE_ID]"`)
+}
+
+func TestCmdOpenInvalidAccountIndex(t *testing.T) {
+	set := flag.NewFlagSet("test", 0)
+	set.Int("accountIndex", -1, "doc")
+	app, _, _ := appWithTestWriters()
+	assert.EqualError(t, command.CmdOpen(&runner.Test{})(cli.NewContext(app, set, nil)), "Invalid accountIndex: -1")
+}
+
+func TestCmdOpenUser(t *testing.T) {
+	set := flag.NewFlagSet("test", 0)
+	set.String("user", "boss@example.com", "doc")
+	app, _, _ := appWithTestWriters()
+	assert.EqualError(
+		t,
+		command.CmdOpen(&runner.Test{})(cli.NewContext(app, set, nil)),
+		"open can not show a delegated mailbox, switch to it in Gmail and use --accountIndex instead of --user",
+	)
+}
+
+// runOpen runs CmdOpen expecting the browser to be opened at webURL
+func runOpen(t *testing.T, webURL string, exitCode int, setFlags func(*flag.FlagSet)) (string, error) {
+	set := flag.NewFlagSet("test", 0)
+	setFlags(set)
+	app, writer, _ := appWithTestWriters()
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{
+		runner.NewExpectedCommand("", regexp.QuoteMeta("xdg-open "+webURL), "", exitCode),
+	}}
+	err := command.CmdOpen(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	return writer.String(), err
+}
@@ -0,0 +1,304 @@
+package command_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// fakeGraphServer is the Microsoft identity platform and the Graph mail API for one mailbox.
+// Messages are returned one per page so that next links are followed.
+type fakeGraphServer struct {
+	*httptest.Server
+	t          *testing.T
+	mutex      sync.Mutex
+	authScopes []string
+	unread     map[string][]string
+	// messageLists is how many pages of unread messages were listed
+	messageLists int
+}
+
+var fakeGraphFolders = []map[string]interface{}{
+	{"id": "AAMkInbox", "displayName": "Inbox", "childFolderCount": 1},
+	{"id": "AAMkArchive", "displayName": "Archive", "childFolderCount": 0},
+}
+
+var fakeGraphChildFolders = map[string][]map[string]interface{}{
+	"AAMkInbox": {{"id": "AAMkReceipts", "displayName": "Receipts", "childFolderCount": 0}},
+}
+
+func TestCmdCheckOutlook(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	output, err := runOutlookCheck(t, cb, tokenFile, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	lines := strings.Split(output, "\n")
+	assert.Contains(t, lines[0], "Attempting to open "+server.URL+"/login/common/oauth2/v2.0/authorize")
+	assert.Equal(t, `{"total":2,"counts":[{"account":"alice@contoso.com","label":"INBOX","unread":2,"messages":2}]}`, lines[1])
+	assert.Equal(t, []string{"offline_access User.Read Mail.Read"}, server.getAuthScopes())
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	tokenFileContents, err := ioutil.ReadFile(tokenFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(tokenFileContents), `"access_token":"outlookToken"`)
+}
+
+func TestCmdCheckOutlookCachedToken(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(`{"access_token":"outlookToken","token_type":"Bearer"}`), 0600))
+	output, err := runOutlookCheck(t, &runner.Test{}, tokenFile, func(set *flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", output)
+	assert.Equal(t, []string(nil), server.getAuthScopes())
+}
+
+func TestCmdCheckOutlookRefreshedToken(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	expired := `{"access_token":"expired","token_type":"Bearer","refresh_token":"oldRefresh","expiry":"2000-01-01T00:00:00Z"}`
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(expired), 0600))
+	output, err := runOutlookCheck(t, &runner.Test{}, tokenFile, func(set *flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", output)
+	tokenFileContents, err := ioutil.ReadFile(tokenFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(tokenFileContents), `"access_token":"outlookToken"`)
+	assert.Contains(t, string(tokenFileContents), `"refresh_token":"newRefresh"`)
+}
+
+func TestCmdCheckOutlookGraphError(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(`{"access_token":"expired","token_type":"Bearer"}`), 0600))
+	_, err := runOutlookCheck(t, &runner.Test{}, tokenFile, func(set *flag.FlagSet) {})
+	assert.EqualError(t, err, "Unable to check Outlook folder INBOX: InvalidAuthenticationToken: Access token has expired or is not yet valid.")
+}
+
+func TestCmdCheckOutlookNoTokenFile(t *testing.T) {
+	output, err := runOutlookCheck(t, &runner.Test{}, "", func(set *flag.FlagSet) {})
+	assert.EqualError(t, err, "You must specify an outlookTokenFile")
+	assert.Equal(t, "", output)
+}
+
+func TestCmdCheckOutlookWatchListsMessages(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(`{"access_token":"outlookToken","token_type":"Bearer"}`), 0600))
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	app, writer, errWriter := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.String("outlookClientID", "outlookClient", "doc")
+	set.String("outlookTokenFile", tokenFile, "doc")
+	set.Bool("watch", true, "doc")
+	set.Bool("notify", true, "doc")
+	set.Duration("interval", time.Millisecond, "doc")
+	assert.Nil(t, command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil)))
+	assert.Equal(t, "2\n2\n", writer.String())
+	assert.Equal(t, "", errWriter.String())
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 4, server.messageLists)
+}
+
+func TestCmdFoldersOutlook(t *testing.T) {
+	server := startFakeGraphServer(t)
+	defer server.Close()
+	tokenFile := tempTokenFile(t)
+	defer removeFile(t, filepath.Dir(tokenFile))
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte(`{"access_token":"outlookToken","token_type":"Bearer"}`), 0600))
+	server.unread["AAMkReceipts"] = []string{"r1"}
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.String("outlookClientID", "outlookClient", "doc")
+	set.String("outlookTokenFile", tokenFile, "doc")
+	assert.Nil(t, command.CmdFolders(&runner.Test{})(cli.NewContext(app, set, nil)))
+	assert.Equal(
+		t,
+		strings.Join([]string{
+			"ACCOUNT            FOLDER          UNREAD",
+			"alice@contoso.com  Inbox           2",
+			"alice@contoso.com  Archive         0",
+			"alice@contoso.com  Inbox/Receipts  1",
+			"",
+		}, "\n"),
+		writer.String(),
+	)
+}
+
+// runOutlookCheck checks the Outlook inbox of the fake server using tokenFile
+func runOutlookCheck(t *testing.T, cb *runner.Test, tokenFile string, setFlags func(*flag.FlagSet)) (string, error) {
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.String("outlookClientID", "outlookClient", "doc")
+	set.String("outlookTenant", "common", "doc")
+	set.String("outlookTokenFile", tokenFile, "doc")
+	setFlags(set)
+	err := command.CmdCheck(cb)(cli.NewContext(app, set, nil))
+	return writer.String(), err
+}
+
+func tempTokenFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "testUnreadChecker")
+	assert.Nil(t, err)
+	return filepath.Join(dir, "outlookToken")
+}
+
+func startFakeGraphServer(t *testing.T) *fakeGraphServer {
+	server := &fakeGraphServer{
+		t:      t,
+		unread: map[string][]string{"AAMkInbox": {"o3", "o2"}},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	command.MicrosoftLoginURL = server.URL + "/login"
+	command.GraphURL = server.URL + "/graph"
+	return server
+}
+
+func (server *fakeGraphServer) Close() {
+	server.Server.Close()
+	command.MicrosoftLoginURL = "https://login.microsoftonline.com"
+	command.GraphURL = "https://graph.microsoft.com/v1.0"
+}
+
+func (server *fakeGraphServer) getAuthScopes() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.authScopes
+}
+
+func (server *fakeGraphServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t := server.t
+	switch r.URL.Path {
+	case "/login/common/oauth2/v2.0/authorize":
+		server.mutex.Lock()
+		server.authScopes = append(server.authScopes, r.FormValue("scope"))
+		server.mutex.Unlock()
+		go func() {
+			_, err := http.Get(fmt.Sprintf("%s?code=foo", r.FormValue("redirect_uri")))
+			assert.Nil(t, err)
+		}()
+		return
+	case "/login/common/oauth2/v2.0/token":
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("grant_type") == "refresh_token" {
+			assert.Equal(t, "oldRefresh", r.FormValue("refresh_token"))
+			writeJSON(t, w, map[string]interface{}{"access_token": "outlookToken", "token_type": "Bearer", "refresh_token": "newRefresh", "expires_in": 3600})
+			return
+		}
+
+		assert.Equal(t, "foo", r.FormValue("code"))
+		writeJSON(t, w, map[string]interface{}{"access_token": "outlookToken", "token_type": "Bearer", "refresh_token": "refresh"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer outlookToken" {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(t, w, map[string]interface{}{"error": map[string]string{
+			"code":    "InvalidAuthenticationToken",
+			"message": "Access token has expired or is not yet valid.",
+		}})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/graph")
+	switch {
+	case path == "/me":
+		assert.Equal(t, "mail,userPrincipalName", r.FormValue("$select"))
+		writeJSON(t, w, map[string]string{"mail": "alice@contoso.com", "userPrincipalName": "alice@contoso.onmicrosoft.com"})
+	case path == "/me/mailFolders":
+		writeJSON(t, w, map[string]interface{}{"value": server.withUnreadCounts(fakeGraphFolders)})
+	case path == "/me/mailFolders/inbox":
+		writeJSON(t, w, server.withUnreadCounts(fakeGraphFolders[:1])[0])
+	case path == "/me/mailFolders/archive":
+		writeJSON(t, w, server.withUnreadCounts(fakeGraphFolders[1:])[0])
+	case strings.HasSuffix(path, "/childFolders"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/me/mailFolders/"), "/childFolders")
+		writeJSON(t, w, map[string]interface{}{"value": server.withUnreadCounts(fakeGraphChildFolders[id])})
+	case strings.HasSuffix(path, "/messages"):
+		server.serveMessages(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/me/mailFolders/"), "/messages"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveMessages returns one unread message per page, with a next link to the rest
+func (server *fakeGraphServer) serveMessages(w http.ResponseWriter, r *http.Request, id string) {
+	t := server.t
+	orderBy := strings.Fields(r.FormValue("$orderby"))
+	if len(orderBy) != 0 && !strings.HasPrefix(r.FormValue("$filter"), orderBy[0]+" ") {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(t, w, map[string]interface{}{"error": map[string]string{
+			"code":    "InefficientFilter",
+			"message": "The restriction or sort order is too complex for this operation.",
+		}})
+		return
+	}
+
+	assert.Equal(t, "receivedDateTime ge 1900-01-01 and isRead eq false", r.FormValue("$filter"))
+	assert.Equal(t, "receivedDateTime desc", r.FormValue("$orderby"))
+	skip, _ := strconv.Atoi(r.FormValue("$skip"))
+	server.mutex.Lock()
+	server.messageLists++
+	unread := server.unread[id]
+	server.mutex.Unlock()
+	page := map[string]interface{}{"value": []map[string]string{}}
+	if skip < len(unread) {
+		page["value"] = []map[string]string{{"id": unread[skip]}}
+	}
+
+	if skip+1 < len(unread) {
+		next := *r.URL
+		query := next.Query()
+		query.Set("$skip", strconv.Itoa(skip+1))
+		next.RawQuery = query.Encode()
+		page["@odata.nextLink"] = server.URL + next.String()
+	}
+
+	writeJSON(t, w, page)
+}
+
+// withUnreadCounts copies folders and adds their unread counts
+func (server *fakeGraphServer) withUnreadCounts(folders []map[string]interface{}) []map[string]interface{} {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	result := make([]map[string]interface{}, 0, len(folders))
+	for _, folder := range folders {
+		copied := map[string]interface{}{}
+		for key, value := range folder {
+			copied[key] = value
+		}
+
+		copied["unreadItemCount"] = len(server.unread[folder["id"].(string)])
+		result = append(result, copied)
+	}
+
+	return result
+}
@@ -0,0 +1,142 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// accountFlags are the flags that add accounts other than Gmail
+var accountFlags = []string{"imap", "maildir", "mbox", "jmap"}
+
+// provider is a mail account that can be checked for unread messages
+type provider interface {
+	// name identifies the account in results
+	name() string
+	// folders returns the labels or folders in the account
+	folders() ([]string, error)
+	// countUnread returns the number of unread messages in a folder
+	countUnread(folder string) (int, error)
+	// listUnread returns the ids of the unread messages in a folder, newest first.
+	// If limit is positive at most limit ids are returned.
+	listUnread(folder string, limit int) ([]string, error)
+}
+
+// pushProvider is a provider that can report changes as they happen instead of only being polled
+type pushProvider interface {
+	provider
+	// watch sends to changes whenever folder may have changed, and reports connection problems to errWriter, until stop is called
+	watch(folder string, changes chan<- struct{}, errWriter io.Writer) (stop func())
+}
+
+// threadCounter is a provider that can count conversations as well as messages
+type threadCounter interface {
+	// countUnreadThreads returns the number of conversations with unread messages in a folder
+	countUnreadThreads(folder string) (int, error)
+}
+
+// providerFolder is a folder that is checked in an account.  An empty folder means every folder in the account.
+type providerFolder struct {
+	provider provider
+	folder   string
+}
+
+// newAccounts returns the folders to check in the accounts other than Gmail
+func newAccounts(c *cli.Context, cmdBuilder runner.Builder) ([]providerFolder, error) {
+	accounts := []providerFolder{}
+	delegated, err := newServiceAccountProviders(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range delegated {
+		accounts = append(accounts, providerFolder{provider: account, folder: defaultLabel})
+	}
+
+	outlook, err := newOutlookProvider(c, cmdBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	if outlook != nil {
+		accounts = append(accounts, providerFolder{provider: outlook, folder: defaultLabel})
+	}
+
+	imapAccounts, err := newIMAPProviders(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range imapAccounts {
+		accounts = append(accounts, providerFolder{provider: account, folder: account.mailbox})
+	}
+
+	jmapAccounts, err := newJMAPProviders(c)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts = append(accounts, jmapAccounts...)
+	for _, root := range globalStringSlice(c, "maildir") {
+		accounts = append(accounts, providerFolder{provider: maildirProvider{root: root}})
+	}
+
+	for _, path := range globalStringSlice(c, "mbox") {
+		accounts = append(accounts, providerFolder{provider: mboxProvider{path: path}})
+	}
+
+	return accounts, nil
+}
+
+// gmailProvider is a Gmail account accessed through the Gmail API
+type gmailProvider struct {
+	srv  *gmail.Service
+	user string
+	// address is the email address of the mailbox, which is looked up when user is another name for it like "me"
+	address string
+	// fetcher reads the metadata of the account's messages
+	fetcher *messageFetcher
+}
+
+func (account gmailProvider) name() string {
+	if account.address != "" {
+		return account.address
+	}
+
+	return account.user
+}
+
+func (account gmailProvider) folders() ([]string, error) {
+	resp, err := account.srv.Users.Labels.List(account.user).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list labels. %v", err)
+	}
+
+	labels := make([]string, 0, len(resp.Labels))
+	for _, label := range resp.Labels {
+		labels = append(labels, label.Id)
+	}
+
+	return labels, nil
+}
+
+func (account gmailProvider) countUnread(folder string) (int, error) {
+	label, err := account.srv.Users.Labels.Get(account.user, folder).Do()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to get label %s. %v", folder, err)
+	}
+
+	return int(label.MessagesUnread), nil
+}
+
+func (account gmailProvider) listUnread(folder string, limit int) ([]string, error) {
+	return listUnread(account.srv, account.user, folder, limit)
+}
+
+func (account gmailProvider) countUnreadThreads(folder string) (int, error) {
+	return countUnreadThreads(account.srv, account.user, []string{folder})
+}
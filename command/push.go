@@ -0,0 +1,50 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// PushReconnectBackoff is how long to wait before reconnecting a dropped push connection.  It doubles after each failure.
+var PushReconnectBackoff = time.Second
+
+const maxPushReconnectBackoff = 5 * time.Minute
+
+// keepConnected runs session until done is closed, reconnecting with backoff whenever it fails.
+// session returns whether it connected successfully, which resets the backoff.  describe names the connection in errors.
+func keepConnected(describe func() string, done <-chan struct{}, errWriter io.Writer, session func(reconnect bool) (bool, error)) {
+	backoff := PushReconnectBackoff
+	for reconnect := false; ; reconnect = true {
+		connected, err := session(reconnect)
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if connected {
+			backoff = PushReconnectBackoff
+		}
+
+		fmt.Fprintf(errWriter, "%s failed, reconnecting in %s: %v\n", describe(), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-done:
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxPushReconnectBackoff {
+			backoff = maxPushReconnectBackoff
+		}
+	}
+}
+
+// notifyChange sends to changes without blocking.  Changes that arrive before the last one is handled are merged.
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/oauth2/google"
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/urfave/cli"
+)
+
+// newServiceAccountProviders returns a Gmail account for each user given with --impersonate,
+// authorized by the --serviceAccountFile key through domain-wide delegation
+func newServiceAccountProviders(c *cli.Context) ([]gmailProvider, error) {
+	keyFile := globalString(c, "serviceAccountFile")
+	users := globalStringSlice(c, "impersonate")
+	if keyFile == "" {
+		if len(users) != 0 {
+			return nil, cli.NewExitError("You must specify a serviceAccountFile to impersonate users", 1)
+		}
+
+		return nil, nil
+	}
+
+	if len(users) == 0 {
+		return nil, cli.NewExitError("You must specify the users to impersonate with the service account", 1)
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Unable to read service account file %s: %v", keyFile, err), 1)
+	}
+
+	config, err := google.JWTConfigFromJSON(key, gmail.GmailReadonlyScope)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Invalid service account file %s: %v", keyFile, err), 1)
+	}
+
+	accounts := make([]gmailProvider, 0, len(users))
+	for _, user := range users {
+		userConfig := *config
+		userConfig.Subject = user
+		httpClient := userConfig.Client(context.Background())
+		srv, _ := gmail.New(httpClient)
+		if BasePath != "" {
+			srv.BasePath = BasePath
+		}
+
+		accounts = append(accounts, gmailProvider{srv: srv, user: user, fetcher: newMessageFetcher(srv, httpClient, "From", "Subject", "Date")})
+	}
+
+	return accounts, nil
+}
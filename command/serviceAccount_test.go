@@ -0,0 +1,280 @@
+package command_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+const testServiceAccount = "checker@example-project.iam.gserviceaccount.com"
+
+// fakeDelegationServer is a Google token endpoint that grants a token for any impersonated user it knows,
+// and a Gmail API that only answers for the user a token was granted for
+type fakeDelegationServer struct {
+	*httptest.Server
+	t      *testing.T
+	mutex  sync.Mutex
+	claims []map[string]interface{}
+	// unread holds the unread message ids of each user for each check, the last of which is repeated
+	unread map[string][][]string
+	// labels are the labels the unread messages are expected to be listed with
+	labels []string
+}
+
+func TestCmdCheckServiceAccount(t *testing.T) {
+	server := startFakeDelegationServer(t)
+	defer server.Close()
+	keyFile := writeServiceAccountKey(t, server.URL+"/token")
+	defer removeFile(t, filepath.Dir(keyFile))
+	output, err := runServiceAccountCheck(t, keyFile, []string{"alice@example.com", "bob@example.com"}, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		`{"total":3,"counts":[{"account":"alice@example.com","label":"INBOX","unread":2,"messages":2},`+
+			`{"account":"bob@example.com","label":"INBOX","unread":1,"messages":1}]}`+"\n",
+		output,
+	)
+	claims := server.getClaims()
+	assert.Len(t, claims, 2)
+	for index, subject := range []string{"alice@example.com", "bob@example.com"} {
+		assert.Equal(t, subject, claims[index]["sub"])
+		assert.Equal(t, testServiceAccount, claims[index]["iss"])
+		assert.Equal(t, gmail.GmailReadonlyScope, claims[index]["scope"])
+		assert.Equal(t, server.URL+"/token", claims[index]["aud"])
+	}
+}
+
+func TestCmdCheckServiceAccountNotDelegated(t *testing.T) {
+	server := startFakeDelegationServer(t)
+	defer server.Close()
+	keyFile := writeServiceAccountKey(t, server.URL+"/token")
+	defer removeFile(t, filepath.Dir(keyFile))
+	_, err := runServiceAccountCheck(t, keyFile, []string{"mallory@example.com"}, func(set *flag.FlagSet) {})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unable to check inbox.")
+	assert.Contains(t, err.Error(), "unauthorized_client")
+}
+
+func TestCmdCheckServiceAccountPrimary(t *testing.T) {
+	server := startFakeDelegationServer(t)
+	defer server.Close()
+	server.labels = []string{"INBOX", "CATEGORY_PERSONAL"}
+	keyFile := writeServiceAccountKey(t, server.URL+"/token")
+	defer removeFile(t, filepath.Dir(keyFile))
+	output, err := runServiceAccountCheck(t, keyFile, []string{"alice@example.com"}, func(set *flag.FlagSet) {
+		set.Bool("primary", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", output)
+}
+
+func TestCmdCheckServiceAccountNotify(t *testing.T) {
+	server := startFakeDelegationServer(t)
+	defer server.Close()
+	server.unread["alice@example.com"] = [][]string{{"a1"}, {"a2", "a1"}}
+	keyFile := writeServiceAccountKey(t, server.URL+"/token")
+	defer removeFile(t, filepath.Dir(keyFile))
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{
+		runner.NewExpectedCommand("", "notify-send --app-name=unreadChecker --icon=mail-unread 1 new message Carol &lt;carol@example.com&gt;: Budget a2", "", 0),
+	}}
+	app, _, errWriter := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.String("serviceAccountFile", keyFile, "doc")
+	set.Var(&cli.StringSlice{"alice@example.com"}, "impersonate", "doc")
+	set.Bool("watch", true, "doc")
+	set.Duration("interval", time.Millisecond, "doc")
+	set.Bool("notify", true, "doc")
+	set.Int("notifyMax", 3, "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	assert.Equal(t, "", errWriter.String())
+}
+
+func TestCmdCheckServiceAccountWithGmail(t *testing.T) {
+	server := startFakeDelegationServer(t)
+	defer server.Close()
+	keyFile := writeServiceAccountKey(t, server.URL+"/token")
+	defer removeFile(t, filepath.Dir(keyFile))
+	_, err := runServiceAccountCheck(t, keyFile, []string{"alice@example.com"}, func(set *flag.FlagSet) {
+		set.String("tokenFile", filepath.Join(filepath.Dir(keyFile), "token.json"), "doc")
+	})
+	assert.EqualError(t, err, "You must specify a credentialFile")
+}
+
+func TestCmdCheckServiceAccountErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testUnreadChecker")
+	assert.Nil(t, err)
+	defer removeFile(t, dir)
+	invalidKey := filepath.Join(dir, "invalid.json")
+	assert.Nil(t, ioutil.WriteFile(invalidKey, []byte(`{"type":"service_account"`), 0600))
+	missingKey := filepath.Join(dir, "missing.json")
+	tests := []struct {
+		keyFile  string
+		users    []string
+		expected string
+	}{
+		{"", []string{"alice@example.com"}, "You must specify a serviceAccountFile to impersonate users"},
+		{invalidKey, nil, "You must specify the users to impersonate with the service account"},
+		{missingKey, []string{"alice@example.com"}, "Unable to read service account file " + missingKey + ": open " + missingKey + ": no such file or directory"},
+		{invalidKey, []string{"alice@example.com"}, "Invalid service account file " + invalidKey + ": unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		_, err := runServiceAccountCheck(t, test.keyFile, test.users, func(set *flag.FlagSet) {})
+		assert.EqualError(t, err, test.expected)
+	}
+}
+
+func runServiceAccountCheck(t *testing.T, keyFile string, users []string, setFlags func(*flag.FlagSet)) (string, error) {
+	app, writer, _ := appWithTestWriters()
+	set := flag.NewFlagSet("test", 0)
+	set.String("serviceAccountFile", keyFile, "doc")
+	impersonate := cli.StringSlice(users)
+	set.Var(&impersonate, "impersonate", "doc")
+	setFlags(set)
+	err := command.CmdCheck(&runner.Test{})(cli.NewContext(app, set, nil))
+	return writer.String(), err
+}
+
+// writeServiceAccountKey writes a service account key with a new private key that gets tokens from tokenURL
+func writeServiceAccountKey(t *testing.T, tokenURL string) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+	key, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "example-project",
+		"private_key_id": "key1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   testServiceAccount,
+		"client_id":      "1234",
+		"token_uri":      tokenURL,
+	})
+	assert.Nil(t, err)
+	dir, err := ioutil.TempDir("", "testUnreadChecker")
+	assert.Nil(t, err)
+	keyFile := filepath.Join(dir, "serviceAccount.json")
+	assert.Nil(t, ioutil.WriteFile(keyFile, key, 0600))
+	return keyFile
+}
+
+func startFakeDelegationServer(t *testing.T) *fakeDelegationServer {
+	server := &fakeDelegationServer{
+		t: t,
+		unread: map[string][][]string{
+			"alice@example.com": {{"a2", "a1"}},
+			"bob@example.com":   {{"b1"}},
+		},
+		labels: []string{"INBOX"},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	command.BasePath = server.URL
+	return server
+}
+
+func (server *fakeDelegationServer) getClaims() []map[string]interface{} {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.claims
+}
+
+func (server *fakeDelegationServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		server.serveToken(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	user := parts[0]
+	if r.Header.Get("Authorization") != "Bearer token-"+user {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if len(parts) == 3 && parts[1] == "messages" {
+		writeJSON(server.t, w, gmail.Message{Id: parts[2], Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "From", Value: "Carol <carol@example.com>"},
+			{Name: "Subject", Value: "Budget " + parts[2]},
+		}}})
+		return
+	}
+
+	if len(parts) != 2 || parts[1] != "messages" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	assert.Equal(server.t, server.labels, r.URL.Query()["labelIds"])
+	server.mutex.Lock()
+	checks := server.unread[user]
+	if len(checks) > 1 {
+		server.unread[user] = checks[1:]
+	}
+
+	server.mutex.Unlock()
+	messages := []*gmail.Message{}
+	for _, id := range checks[0] {
+		messages = append(messages, &gmail.Message{Id: id})
+	}
+
+	writeJSON(server.t, w, gmail.ListMessagesResponse{Messages: messages})
+}
+
+// serveToken exchanges a signed JWT assertion for a token for the user in its subject
+func (server *fakeDelegationServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	assert.Nil(server.t, r.ParseForm())
+	assert.Equal(server.t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+	parts := strings.Split(r.Form.Get("assertion"), ".")
+	if !assert.Len(server.t, parts, 3) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.Nil(server.t, err)
+	claims := map[string]interface{}{}
+	assert.Nil(server.t, json.Unmarshal(payload, &claims))
+	server.mutex.Lock()
+	server.claims = append(server.claims, claims)
+	server.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	subject, _ := claims["sub"].(string)
+	server.mutex.Lock()
+	_, ok := server.unread[subject]
+	server.mutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(server.t, w, map[string]string{
+			"error":             "unauthorized_client",
+			"error_description": "Client is unauthorized to retrieve access tokens using this method, or client not authorized for any of the scopes requested.",
+		})
+		return
+	}
+
+	writeJSON(server.t, w, map[string]interface{}{"access_token": "token-" + subject, "token_type": "Bearer", "expires_in": 3600})
+}
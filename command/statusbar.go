@@ -0,0 +1,313 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	levelOK       = "ok"
+	levelWarning  = "warning"
+	levelCritical = "critical"
+	levelError    = "error"
+)
+
+// formatter renders the results of a check
+type formatter interface {
+	// header is written once before any results
+	header() string
+	// format renders a single set of results
+	format(counts []unreadCount, err error) string
+}
+
+// barStyle holds the threshold based colors used by the status bar formats
+type barStyle struct {
+	warnThreshold int
+	critThreshold int
+	okColor       string
+	warnColor     string
+	critColor     string
+}
+
+func newFormatter(c *cli.Context, watch bool) (formatter, error) {
+	style := barStyle{
+		warnThreshold: c.Int("warnThreshold"),
+		critThreshold: c.Int("critThreshold"),
+		okColor:       c.String("okColor"),
+		warnColor:     c.String("warnColor"),
+		critColor:     c.String("critColor"),
+	}
+
+	switch c.String("format") {
+	case "", "plain":
+		return plainFormatter{both: c.String("count") == countBoth}, nil
+	case "i3blocks":
+		return i3blocksFormatter{style: style, watch: watch}, nil
+	case "polybar":
+		return polybarFormatter{style: style}, nil
+	case "waybar":
+		return waybarFormatter{style: style}, nil
+	case "i3bar":
+		return i3barFormatter{style: style}, nil
+	case "json":
+		return jsonFormatter{}, nil
+	}
+
+	return nil, cli.NewExitError(fmt.Sprintf("Invalid format: %s", c.String("format")), 1)
+}
+
+// isStatusBar is whether format is for a status bar, which shows errors in the bar
+func isStatusBar(format string) bool {
+	switch format {
+	case "i3blocks", "polybar", "waybar", "i3bar":
+		return true
+	}
+
+	return false
+}
+
+func (style barStyle) level(total int) string {
+	if style.critThreshold > 0 && total >= style.critThreshold {
+		return levelCritical
+	}
+
+	if style.warnThreshold > 0 && total >= style.warnThreshold {
+		return levelWarning
+	}
+
+	return levelOK
+}
+
+func (style barStyle) color(level string) string {
+	switch level {
+	case levelWarning:
+		return style.warnColor
+	case levelCritical, levelError:
+		return style.critColor
+	}
+
+	return style.okColor
+}
+
+// percentage is how close total is to the critical threshold
+func (style barStyle) percentage(total int) int {
+	if style.critThreshold <= 0 || total >= style.critThreshold {
+		return 100
+	}
+
+	return total * 100 / style.critThreshold
+}
+
+// barText returns the text, tooltip and level to display for a set of results
+func barText(style barStyle, counts []unreadCount, err error) (string, string, string) {
+	if err != nil {
+		return "!", err.Error(), levelError
+	}
+
+	total := totalUnread(counts)
+	tooltip := make([]string, 0, len(counts))
+	for _, count := range counts {
+		tooltip = append(tooltip, fmt.Sprintf("%s %s: %d", count.Account, count.Label, count.Unread))
+	}
+
+	return fmt.Sprintf("%s%d", delegatedPrefix(counts), total), strings.Join(tooltip, "\n"), style.level(total)
+}
+
+// delegatedPrefix names the delegated mailboxes in the results, so a total that includes someone else's mail says whose it is
+func delegatedPrefix(counts []unreadCount) string {
+	addresses := []string{}
+	for _, count := range counts {
+		if count.delegated {
+			addresses = append(addresses, count.Account)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return ""
+	}
+
+	return strings.Join(addresses, ", ") + ": "
+}
+
+func totalUnread(counts []unreadCount) int {
+	total := 0
+	for _, count := range counts {
+		total += count.Unread
+	}
+
+	return total
+}
+
+// totalThreads adds up the threads of the accounts that can count them, along with the messages of the accounts that can not
+func totalThreads(counts []unreadCount) (int, int) {
+	threads := 0
+	unthreaded := 0
+	for _, count := range counts {
+		if count.Threads != nil {
+			threads += *count.Threads
+		} else {
+			unthreaded += count.Messages
+		}
+	}
+
+	return threads, unthreaded
+}
+
+// plainFormatter writes the total.  When both messages and threads are counted it writes the thread total after it,
+// along with the messages of accounts that have no threads.
+type plainFormatter struct {
+	both bool
+}
+
+func (plainFormatter) header() string {
+	return ""
+}
+
+func (formatter plainFormatter) format(counts []unreadCount, err error) string {
+	if err != nil {
+		return ""
+	}
+
+	total := fmt.Sprintf("%s%d\n", delegatedPrefix(counts), totalUnread(counts))
+	if formatter.both {
+		threads, unthreaded := totalThreads(counts)
+		total = fmt.Sprintf("%s%d (%d threads)\n", delegatedPrefix(counts), totalUnread(counts), threads)
+		if unthreaded != 0 {
+			total = fmt.Sprintf("%s%d (%d threads + %d unthreaded messages)\n", delegatedPrefix(counts), totalUnread(counts), threads, unthreaded)
+		}
+	}
+
+	if categories := mergeCategories(counts); categories != nil {
+		total += formatCategories(categories)
+	}
+
+	if ages := mergeAges(counts); ages != nil {
+		total += formatAges(ages)
+	}
+
+	return total
+}
+
+// jsonFormatter writes a JSON object per check
+type jsonFormatter struct{}
+
+type jsonOutput struct {
+	Total  int           `json:"total"`
+	Counts []unreadCount `json:"counts"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func (jsonFormatter) header() string {
+	return ""
+}
+
+func (jsonFormatter) format(counts []unreadCount, err error) string {
+	if err != nil {
+		return marshalLine(jsonOutput{Counts: []unreadCount{}, Error: err.Error()})
+	}
+
+	return marshalLine(jsonOutput{Total: totalUnread(counts), Counts: counts})
+}
+
+// i3blocksFormatter writes the full_text, short_text and color lines for a one-shot block.
+// In watch mode it writes a JSON object per line for blocks using interval=persist and format=json.
+type i3blocksFormatter struct {
+	style barStyle
+	watch bool
+}
+
+func (i3blocksFormatter) header() string {
+	return ""
+}
+
+func (formatter i3blocksFormatter) format(counts []unreadCount, err error) string {
+	text, _, level := barText(formatter.style, counts, err)
+	color := formatter.style.color(level)
+	if formatter.watch {
+		return marshalLine(i3barBlock{FullText: text, ShortText: text, Color: color, Urgent: level == levelCritical || level == levelError})
+	}
+
+	return fmt.Sprintf("%s\n%s\n%s\n", text, text, color)
+}
+
+type polybarFormatter struct {
+	style barStyle
+}
+
+func (polybarFormatter) header() string {
+	return ""
+}
+
+func (formatter polybarFormatter) format(counts []unreadCount, err error) string {
+	text, _, level := barText(formatter.style, counts, err)
+	color := formatter.style.color(level)
+	if color == "" {
+		return fmt.Sprintf("%s\n", text)
+	}
+
+	return fmt.Sprintf("%%{F%s}%s%%{F-}\n", color, text)
+}
+
+type waybarFormatter struct {
+	style barStyle
+}
+
+type waybarOutput struct {
+	Text       string `json:"text"`
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage"`
+}
+
+func (waybarFormatter) header() string {
+	return ""
+}
+
+func (formatter waybarFormatter) format(counts []unreadCount, err error) string {
+	text, tooltip, level := barText(formatter.style, counts, err)
+	percentage := 100
+	if err == nil {
+		percentage = formatter.style.percentage(totalUnread(counts))
+	}
+
+	return marshalLine(waybarOutput{Text: text, Tooltip: tooltip, Class: level, Percentage: percentage})
+}
+
+// i3barFormatter implements the i3bar protocol, which is also used by swaybar.
+// The protocol is an endless JSON array, so each status line is followed by a comma.
+type i3barFormatter struct {
+	style barStyle
+}
+
+type i3barBlock struct {
+	Name      string `json:"name,omitempty"`
+	FullText  string `json:"full_text"`
+	ShortText string `json:"short_text,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Urgent    bool   `json:"urgent,omitempty"`
+}
+
+func (i3barFormatter) header() string {
+	return "{\"version\":1}\n[\n"
+}
+
+func (formatter i3barFormatter) format(counts []unreadCount, err error) string {
+	text, _, level := barText(formatter.style, counts, err)
+	block := i3barBlock{
+		Name:     Name,
+		FullText: text,
+		Color:    formatter.style.color(level),
+		Urgent:   level == levelCritical || level == levelError,
+	}
+
+	line := marshalLine([]i3barBlock{block})
+	return strings.TrimSuffix(line, "\n") + ",\n"
+}
+
+func marshalLine(value interface{}) string {
+	bytes, _ := json.Marshal(value)
+	return string(bytes) + "\n"
+}
@@ -0,0 +1,176 @@
+package command_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckPolybar(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, false, func(set *flag.FlagSet) {
+		set.String("format", "polybar", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"%{F#FFFF00}4%{F-}", ""}, output)
+}
+
+func TestCmdCheckPolybarNoColor(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, false, func(set *flag.FlagSet) {
+		set.String("format", "polybar", "doc")
+		assert.Nil(t, set.Set("warnThreshold", "5"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4", ""}, output)
+}
+
+func TestCmdCheckI3blocks(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, false, func(set *flag.FlagSet) {
+		set.String("format", "i3blocks", "doc")
+		assert.Nil(t, set.Set("critThreshold", "3"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4", "4", "#FF0000", ""}, output)
+}
+
+func TestCmdCheckI3blocksWatch(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, true, func(set *flag.FlagSet) {
+		set.String("format", "i3blocks", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			`{"full_text":"4","short_text":"4","color":"#FFFF00"}`,
+			`{"full_text":"4","short_text":"4","color":"#FFFF00"}`,
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdCheckWaybar(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, false, func(set *flag.FlagSet) {
+		set.String("format", "waybar", "doc")
+		assert.Nil(t, set.Set("critThreshold", "10"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`{"text":"4","tooltip":"me@example.com INBOX: 4","class":"warning","percentage":40}`, ""}, output)
+}
+
+func TestCmdCheckI3barWatch(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, true, func(set *flag.FlagSet) {
+		set.String("format", "i3bar", "doc")
+		assert.Nil(t, set.Set("critThreshold", "4"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			`{"version":1}`,
+			"[",
+			`[{"name":"unreadChecker","full_text":"4","color":"#FF0000","urgent":true}],`,
+			`[{"name":"unreadChecker","full_text":"4","color":"#FF0000","urgent":true}],`,
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdCheckPlainWatch(t *testing.T) {
+	output, _, err := runStatusBarCheck(t, true, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4", "4", ""}, output)
+}
+
+func TestCmdCheckWaybarWatchInboxFailure(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPIInboxFailure(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	command.MaxWatchIterations = 2
+	defer func() { command.MaxWatchIterations = 0 }()
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.String("format", "waybar", "doc")
+	set.Bool("watch", true, "doc")
+	set.Duration("interval", time.Millisecond, "doc")
+	set.String("critColor", "#FF0000", "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	errorMessage := "Unable to check inbox. googleapi: got HTTP response code 500 with body: "
+	assert.Equal(
+		t,
+		[]string{
+			`{"text":"!","tooltip":"` + errorMessage + `","class":"error","percentage":100}`,
+			`{"text":"!","tooltip":"` + errorMessage + `","class":"error","percentage":100}`,
+			"",
+		},
+		strings.Split(writer.String(), "\n")[1:],
+	)
+	assert.Equal(t, errorMessage+"\n"+errorMessage+"\n", errWriter.String())
+}
+
+func TestCmdCheckStatusBarInboxFailure(t *testing.T) {
+	errorMessage := "Unable to check inbox. googleapi: got HTTP response code 500 with body: "
+	for format, expected := range map[string][]string{
+		"i3blocks": {"!", "!", "#FF0000", ""},
+		"polybar":  {"%{F#FF0000}!%{F-}", ""},
+		"waybar":   {`{"text":"!","tooltip":"` + errorMessage + `","class":"error","percentage":100}`, ""},
+		"i3bar":    {`{"version":1}`, "[", `[{"name":"unreadChecker","full_text":"!","color":"#FF0000","urgent":true}],`, ""},
+		"plain":    {""},
+	} {
+		testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+		assert.Nil(t, os.MkdirAll(testFolder, 0777))
+		ts := getMockGoogleAPIInboxFailure(t)
+		command.BasePath = ts.URL
+		cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+		app, writer, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+		set.String("format", format, "doc")
+		set.String("critColor", "#FF0000", "doc")
+		assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), errorMessage, format)
+		assert.Equal(t, expected, strings.Split(writer.String(), "\n")[1:], format)
+		ts.Close()
+		removeFile(t, testFolder)
+	}
+}
+
+func TestCmdCheckInvalidFormat(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("format", "foo", "doc")
+	cb := &runner.Test{}
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "Invalid format: foo")
+}
+
+// runStatusBarCheck runs CmdCheck against the mock API and returns the output lines after the OAuth prompt
+func runStatusBarCheck(t *testing.T, watch bool, setFlags func(*flag.FlagSet)) ([]string, string, error) {
+	if watch {
+		command.MaxWatchIterations = 2
+		defer func() { command.MaxWatchIterations = 0 }()
+	}
+
+	output, errOutput, err := runGmailCommand(t, command.CmdCheck, getMockGoogleAPI(t), func(set *flag.FlagSet) {
+		set.Int("warnThreshold", 1, "doc")
+		set.Int("critThreshold", 20, "doc")
+		set.String("warnColor", "#FFFF00", "doc")
+		set.String("critColor", "#FF0000", "doc")
+		if watch {
+			set.Bool("watch", true, "doc")
+			set.Duration("interval", time.Millisecond, "doc")
+		}
+
+		setFlags(set)
+	})
+	return strings.Split(output, "\n"), errOutput, err
+}
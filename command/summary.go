@@ -0,0 +1,177 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+// summaryGroup is the unread messages from one sender, domain or label
+type summaryGroup struct {
+	Name   string    `json:"name"`
+	Count  int       `json:"count"`
+	Oldest time.Time `json:"oldest"`
+}
+
+// unreadSummary is the unread messages grouped by sender, sender domain and label
+type unreadSummary struct {
+	Total   int            `json:"total"`
+	Senders []summaryGroup `json:"senders"`
+	Domains []summaryGroup `json:"domains"`
+	Labels  []summaryGroup `json:"labels"`
+}
+
+// CmdSummary shows who the unread messages in the inbox are from
+func CmdSummary(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 {
+			return cli.NewExitError("Usage: \"unreadChecker summary\"", 1)
+		}
+
+		err := checkFlags(c)
+		if err != nil {
+			return err
+		}
+
+		if c.String("format") != "" && c.String("format") != "table" && c.String("format") != "json" {
+			return cli.NewExitError(fmt.Sprintf("Invalid format: %s", c.String("format")), 1)
+		}
+
+		srv, httpClient, err := getService(c, cmdBuilder)
+		if err != nil {
+			return err
+		}
+
+		user := gmailUser(c)
+		ids, err := listUnreadLabels(srv, user, countedLabels(c, defaultLabel), c.Int("limit"))
+		if err != nil {
+			return err
+		}
+
+		summaries := fetchMessageSummaries(c.App.ErrWriter, newMessageFetcher(srv, httpClient, "From", "Date"), user, ids)
+		summary := summarize(summaries, c.Int("top"))
+		if c.String("format") == "json" {
+			encoder := json.NewEncoder(c.App.Writer)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(summary)
+		}
+
+		return writeSummaryTable(c.App.Writer, summary, time.Now())
+	}
+}
+
+// summarize groups summaries by sender, sender domain and label, keeping the top groups of each
+func summarize(summaries []messageSummary, top int) unreadSummary {
+	senders := map[string]*summaryGroup{}
+	domains := map[string]*summaryGroup{}
+	labels := map[string]*summaryGroup{}
+	for _, summary := range summaries {
+		sender := senderAddress(summary.From)
+		addToGroup(senders, sender, summary.Date)
+		addToGroup(domains, senderDomain(sender), summary.Date)
+		for _, label := range summary.Labels {
+			addToGroup(labels, label, summary.Date)
+		}
+	}
+
+	return unreadSummary{
+		Total:   len(summaries),
+		Senders: topGroups(senders, top),
+		Domains: topGroups(domains, top),
+		Labels:  topGroups(labels, top),
+	}
+}
+
+func addToGroup(groups map[string]*summaryGroup, name string, date time.Time) {
+	group, ok := groups[name]
+	if !ok {
+		group = &summaryGroup{Name: name, Oldest: date}
+		groups[name] = group
+	}
+
+	group.Count++
+	if date.Before(group.Oldest) {
+		group.Oldest = date
+	}
+}
+
+// topGroups returns the largest groups, at most top of them unless top is 0
+func topGroups(groups map[string]*summaryGroup, top int) []summaryGroup {
+	sorted := make([]summaryGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if top > 0 && len(sorted) > top {
+		sorted = sorted[:top]
+	}
+
+	return sorted
+}
+
+// senderAddress returns the lowercase email address from a From header
+func senderAddress(from string) string {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(from))
+	}
+
+	return strings.ToLower(address.Address)
+}
+
+func senderDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return address
+	}
+
+	return address[at+1:]
+}
+
+func writeSummaryTable(writer io.Writer, summary unreadSummary, now time.Time) error {
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	sections := []struct {
+		title  string
+		groups []summaryGroup
+	}{{"SENDER", summary.Senders}, {"DOMAIN", summary.Domains}, {"LABEL", summary.Labels}}
+	for index, section := range sections {
+		if index != 0 {
+			fmt.Fprintln(table)
+		}
+
+		fmt.Fprintf(table, "%s\tCOUNT\tOLDEST\n", section.title)
+		for _, group := range section.groups {
+			fmt.Fprintf(table, "%s\t%d\t%s\n", truncate(group.Name, 40), group.Count, formatAge(now.Sub(group.Oldest)))
+		}
+	}
+
+	return table.Flush()
+}
+
+// formatAge describes how old something is in the largest whole unit
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	}
+}
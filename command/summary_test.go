@@ -0,0 +1,140 @@
+package command_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdSummary(t *testing.T) {
+	now := time.Now()
+	messages := map[string]*gmail.Message{
+		"a": newListTestMessage("a", "CI <ci@builds.example.com>", "", "", summaryDate(now, 3*24*time.Hour), "INBOX", "UNREAD"),
+		"b": newListTestMessage("b", "ci@builds.example.com", "", "", summaryDate(now, 2*time.Hour), "INBOX", "UNREAD", "CATEGORY_UPDATES"),
+		"c": newListTestMessage("c", "Deploys <deploy@builds.example.com>", "", "", summaryDate(now, 5*time.Hour), "INBOX", "UNREAD"),
+		"d": newListTestMessage("d", "alice@example.com", "", "", summaryDate(now, 10*time.Minute), "INBOX", "UNREAD"),
+	}
+	output, err := runSummaryCheck(t, messages, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"SENDER                     COUNT  OLDEST",
+			"ci@builds.example.com      2      3d",
+			"alice@example.com          1      10m",
+			"deploy@builds.example.com  1      5h",
+			"",
+			"DOMAIN              COUNT  OLDEST",
+			"builds.example.com  3      3d",
+			"example.com         1      10m",
+			"",
+			"LABEL             COUNT  OLDEST",
+			"INBOX             4      3d",
+			"UNREAD            4      3d",
+			"CATEGORY_UPDATES  1      2h",
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdSummaryTopJSON(t *testing.T) {
+	output, err := runSummaryCheck(t, listTestMessages, func(set *flag.FlagSet) {
+		assert.Nil(t, set.Set("top", "1"))
+		assert.Nil(t, set.Set("format", "json"))
+	})
+	assert.Nil(t, err)
+	oldest := `"` + time.Unix(1500000000, 0).Format(time.RFC3339) + `"`
+	assert.Equal(
+		t,
+		[]string{
+			"{",
+			`  "total": 3,`,
+			`  "senders": [`,
+			"    {",
+			`      "name": "alice@example.com",`,
+			`      "count": 1,`,
+			`      "oldest": ` + oldest,
+			"    }",
+			"  ],",
+			`  "domains": [`,
+			"    {",
+			`      "name": "example.com",`,
+			`      "count": 3,`,
+			`      "oldest": ` + oldest,
+			"    }",
+			"  ],",
+			`  "labels": [`,
+			"    {",
+			`      "name": "INBOX",`,
+			`      "count": 3,`,
+			`      "oldest": ` + oldest,
+			"    }",
+			"  ]",
+			"}",
+			"",
+		},
+		output,
+	)
+}
+
+func TestCmdSummaryUsage(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	assert.Nil(t, set.Parse([]string{"foo"}))
+	assert.EqualError(t, command.CmdSummary(&runner.Test{})(cli.NewContext(app, set, nil)), `Usage: "unreadChecker summary"`)
+}
+
+func TestCmdSummaryInvalidFormat(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	set.String("format", "xml", "doc")
+	assert.EqualError(t, command.CmdSummary(&runner.Test{})(cli.NewContext(app, set, nil)), "Invalid format: xml")
+}
+
+func TestCmdSummaryInboxFailure(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPIInboxFailure(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	assert.EqualError(t, command.CmdSummary(cb)(cli.NewContext(app, set, nil)), "Unable to check inbox. googleapi: got HTTP response code 500 with body: ")
+}
+
+// runSummaryCheck runs CmdSummary against the mock API with every message unread and returns the output lines after the OAuth prompt
+func runSummaryCheck(t *testing.T, messages map[string]*gmail.Message, setFlags func(*flag.FlagSet)) ([]string, error) {
+	ids := []string{}
+	for id := range messages {
+		ids = append(ids, id)
+	}
+
+	ts := getMockGoogleAPISequence(t, [][]string{ids}, messages)
+	output, _, err := runGmailCommand(t, command.CmdSummary, ts, func(set *flag.FlagSet) {
+		set.Int("top", 10, "doc")
+		set.String("format", "table", "doc")
+		setFlags(set)
+	})
+	return strings.Split(output, "\n"), err
+}
+
+// summaryDate is the internalDate of a message received age ago, rounded so the age is stable during the test
+func summaryDate(now time.Time, age time.Duration) int64 {
+	return now.Add(-age-30*time.Second).UnixNano() / int64(time.Millisecond)
+}
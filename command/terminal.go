@@ -0,0 +1,60 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// enterRawMode puts the terminal that input reads from into raw mode so that keys are read as they are pressed.
+// It returns a function that restores the previous mode.  Input that isn't a terminal is left alone.
+func enterRawMode(input io.Reader) (func(), error) {
+	terminal, ok := input.(*os.File)
+	if !ok {
+		return func() {}, nil
+	}
+
+	previous, err := stty(terminal, "-g")
+	if err != nil {
+		// Not a terminal
+		return func() {}, nil
+	}
+
+	_, err = stty(terminal, "raw", "-echo")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to configure terminal: %v", err)
+	}
+
+	return func() { _, _ = stty(terminal, previous) }, nil
+}
+
+// terminalSize returns the width and height of the terminal that input reads from, or 80x24 if it isn't a terminal
+func terminalSize(input io.Reader) (int, int) {
+	terminal, ok := input.(*os.File)
+	if !ok {
+		return 80, 24
+	}
+
+	size, err := stty(terminal, "size")
+	if err != nil {
+		return 80, 24
+	}
+
+	var width, height int
+	_, err = fmt.Sscanf(size, "%d %d", &height, &width)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+
+	return width, height
+}
+
+// stty runs stty against terminal.  It can't use a runner.Builder because the terminal has to be its stdin.
+func stty(terminal *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = terminal
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
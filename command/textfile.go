@@ -0,0 +1,133 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TextfileName is the name of the file written for the node_exporter textfile collector
+const TextfileName = "unreadChecker.prom"
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeTextfile atomically writes the counts to a .prom file in dir.
+// A failed check is recorded as unreadchecker_last_check_success 0 so that it can be alerted on.
+func writeTextfile(dir string, counts []unreadCount, checkErr error) error {
+	var buffer bytes.Buffer
+	if checkErr == nil {
+		fmt.Fprintln(&buffer, "# HELP unreadchecker_unread_messages Number of unread messages.")
+		fmt.Fprintln(&buffer, "# TYPE unreadchecker_unread_messages gauge")
+		for _, count := range counts {
+			fmt.Fprintf(
+				&buffer,
+				"unreadchecker_unread_messages{account=\"%s\",label=\"%s\"} %d\n",
+				promLabelEscaper.Replace(count.Account),
+				promLabelEscaper.Replace(count.Label),
+				count.Messages,
+			)
+		}
+
+		writeThreadMetrics(&buffer, counts)
+		writeCategoryMetrics(&buffer, counts)
+	}
+
+	success := 0
+	if checkErr == nil {
+		success = 1
+	}
+
+	fmt.Fprintln(&buffer, "# HELP unreadchecker_last_check_success Whether the last check succeeded.")
+	fmt.Fprintln(&buffer, "# TYPE unreadchecker_last_check_success gauge")
+	fmt.Fprintf(&buffer, "unreadchecker_last_check_success %d\n", success)
+	fmt.Fprintln(&buffer, "# HELP unreadchecker_last_check_timestamp_seconds Unix time of the last check.")
+	fmt.Fprintln(&buffer, "# TYPE unreadchecker_last_check_timestamp_seconds gauge")
+	fmt.Fprintf(&buffer, "unreadchecker_last_check_timestamp_seconds %d\n", time.Now().Unix())
+
+	return writeFileAtomically(filepath.Join(dir, TextfileName), buffer.Bytes(), 0644)
+}
+
+// writeThreadMetrics writes the unread thread counts of the accounts that can count threads
+func writeThreadMetrics(buffer *bytes.Buffer, counts []unreadCount) {
+	header := false
+	for _, count := range counts {
+		if count.Threads == nil {
+			continue
+		}
+
+		if !header {
+			fmt.Fprintln(buffer, "# HELP unreadchecker_unread_threads Number of conversations with unread messages.")
+			fmt.Fprintln(buffer, "# TYPE unreadchecker_unread_threads gauge")
+			header = true
+		}
+
+		fmt.Fprintf(
+			buffer,
+			"unreadchecker_unread_threads{account=\"%s\",label=\"%s\"} %d\n",
+			promLabelEscaper.Replace(count.Account),
+			promLabelEscaper.Replace(count.Label),
+			*count.Threads,
+		)
+	}
+}
+
+// writeCategoryMetrics writes the unread counts of the inbox tabs of the accounts they were counted for
+func writeCategoryMetrics(buffer *bytes.Buffer, counts []unreadCount) {
+	header := false
+	for _, count := range counts {
+		if count.Categories == nil {
+			continue
+		}
+
+		if !header {
+			fmt.Fprintln(buffer, "# HELP unreadchecker_unread_category_messages Number of unread messages in each inbox tab.")
+			fmt.Fprintln(buffer, "# TYPE unreadchecker_unread_category_messages gauge")
+			header = true
+		}
+
+		for _, category := range inboxCategories {
+			fmt.Fprintf(
+				buffer,
+				"unreadchecker_unread_category_messages{account=\"%s\",label=\"%s\",category=\"%s\"} %d\n",
+				promLabelEscaper.Replace(count.Account),
+				promLabelEscaper.Replace(count.Label),
+				category.name,
+				count.Categories[category.name],
+			)
+		}
+	}
+}
+
+// writeFileAtomically writes to a temporary file in the same directory and renames it into place
+// so that readers never see a partially written file.
+func writeFileAtomically(fileName string, contents []byte, perm os.FileMode) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to write %s: %v", fileName, err)
+	}
+
+	_, err = tempFile.Write(contents)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), perm)
+	}
+
+	if err == nil {
+		err = os.Rename(tempFile.Name(), fileName)
+	}
+
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+		return fmt.Errorf("Unable to write %s: %v", fileName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package command_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdCheckTextfile(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.String("textfileDir", testFolder, "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	lines := readTextfile(t, testFolder)
+	assert.Equal(
+		t,
+		[]string{
+			"# HELP unreadchecker_unread_messages Number of unread messages.",
+			"# TYPE unreadchecker_unread_messages gauge",
+			`unreadchecker_unread_messages{account="me@example.com",label="INBOX"} 4`,
+			"# HELP unreadchecker_last_check_success Whether the last check succeeded.",
+			"# TYPE unreadchecker_last_check_success gauge",
+			"unreadchecker_last_check_success 1",
+			"# HELP unreadchecker_last_check_timestamp_seconds Unix time of the last check.",
+			"# TYPE unreadchecker_last_check_timestamp_seconds gauge",
+		},
+		lines[:8],
+	)
+	assert.Regexp(t, `^unreadchecker_last_check_timestamp_seconds \d+$`, lines[8])
+	files, err := ioutil.ReadDir(testFolder)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files), "temporary files should not be left behind")
+}
+
+func TestCmdCheckTextfileInboxFailure(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPIInboxFailure(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.String("textfileDir", testFolder, "doc")
+	assert.EqualError(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)), "Unable to check inbox. googleapi: got HTTP response code 500 with body: ")
+	lines := readTextfile(t, testFolder)
+	assert.Equal(
+		t,
+		[]string{
+			"# HELP unreadchecker_last_check_success Whether the last check succeeded.",
+			"# TYPE unreadchecker_last_check_success gauge",
+			"unreadchecker_last_check_success 0",
+		},
+		lines[:3],
+	)
+}
+
+func TestCmdCheckTextfileInvalidDir(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := getMockGoogleAPI(t)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: []*runner.ExpectedCommand{getBrowserCommand(t)}}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	textfileDir := filepath.Join(testFolder, "doesntexist")
+	set.String("textfileDir", textfileDir, "doc")
+	assert.Nil(t, command.CmdCheck(cb)(cli.NewContext(app, set, nil)))
+	assert.True(t, strings.HasSuffix(writer.String(), "\n4\n"))
+	assert.Contains(t, errWriter.String(), "Unable to write "+filepath.Join(textfileDir, "unreadChecker.prom"))
+}
+
+func getBrowserCommand(t *testing.T) *runner.ExpectedCommand {
+	ec := runner.NewExpectedCommand("", "xdg-open.*", "", 0)
+	ec.Closure = func(command string) {
+		go func() {
+			_, err := http.Get(strings.Replace(command, "xdg-open ", "", -1))
+			assert.Nil(t, err)
+		}()
+	}
+
+	return ec
+}
+
+func readTextfile(t *testing.T, dir string) []string {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, command.TextfileName))
+	assert.Nil(t, err)
+	return strings.Split(string(contents), "\n")
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 
 	"github.com/guywithnose/runner"
 
@@ -23,30 +24,72 @@ type Client struct {
 	config         *oauth2.Config
 	tokenCacheFile string
 	cmdBuilder     runner.Builder
+	// baseScopes is how many of the config's scopes are always requested.  Any others are asked for incrementally.
+	baseScopes int
+	// authOptions are added to the authorization URL
+	authOptions []oauth2.AuthCodeOption
 }
 
-// NewClient returns a Client
-func NewClient(appCredentialFile, tokenCacheFile string, cmdBuilder runner.Builder) (*Client, error) {
+// cachedToken is the format of the token cache file.
+// Scopes is only recorded when more than the base scopes have been granted.
+type cachedToken struct {
+	oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// GoogleEndpoint allows overriding Google's OAuth endpoint for testing clients created with NewClientFromID
+var GoogleEndpoint = google.Endpoint
+
+// NewClient returns a Client.  The read only scope is always requested along with any extra scopes.
+func NewClient(appCredentialFile, tokenCacheFile string, cmdBuilder runner.Builder, scopes ...string) (*Client, error) {
 	appCredentials, err := ioutil.ReadFile(appCredentialFile)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to read app credential file: %v", err)
 	}
 
-	config, err := google.ConfigFromJSON(appCredentials, gmail.GmailReadonlyScope)
+	return NewClientFromJSON(appCredentials, tokenCacheFile, cmdBuilder, scopes...)
+}
+
+// NewClientFromJSON returns a Client for app credentials in the format of the credential file from the Google API Console
+func NewClientFromJSON(appCredentials []byte, tokenCacheFile string, cmdBuilder runner.Builder, scopes ...string) (*Client, error) {
+	config, err := google.ConfigFromJSON(appCredentials, append([]string{gmail.GmailReadonlyScope}, scopes...)...)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to parse app credentials: %v", err)
 	}
 
+	return newGoogleClient(config, tokenCacheFile, cmdBuilder), nil
+}
+
+// NewClientFromID returns a Client for an app's client ID and secret
+func NewClientFromID(clientID, clientSecret, tokenCacheFile string, cmdBuilder runner.Builder, scopes ...string) *Client {
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     GoogleEndpoint,
+		Scopes:       append([]string{gmail.GmailReadonlyScope}, scopes...),
+	}
+
+	return newGoogleClient(config, tokenCacheFile, cmdBuilder)
+}
+
+func newGoogleClient(config *oauth2.Config, tokenCacheFile string, cmdBuilder runner.Builder) *Client {
 	return &Client{
 		config:         config,
 		tokenCacheFile: tokenCacheFile,
 		cmdBuilder:     cmdBuilder,
-	}, nil
+		baseScopes:     1,
+		authOptions:    []oauth2.AuthCodeOption{oauth2.AccessTypeOffline},
+	}
 }
 
 // GetHTTPClient gets an oauth token.  If necessary it may open a browser for user authorization.
+// A cached token that is missing any of the requested scopes is replaced by asking for them incrementally.
 func (client Client) GetHTTPClient(writer io.Writer) (*http.Client, error) {
 	token, err := client.tokenFromFile()
+	if err == nil && !client.hasScopes(token) {
+		err = fmt.Errorf("missing scopes")
+	}
+
 	if err != nil {
 		token, err = client.getTokenFromWeb(writer)
 		if err != nil {
@@ -59,12 +102,39 @@ func (client Client) GetHTTPClient(writer io.Writer) (*http.Client, error) {
 		}
 	}
 
-	return client.config.Client(context.Background(), token), err
+	source := &savingTokenSource{client: client, source: client.config.TokenSource(context.Background(), &token.Token), saved: *token}
+	return oauth2.NewClient(context.Background(), source), nil
+}
+
+// savingTokenSource writes tokens back to the token cache file when they are refreshed,
+// so a refresh token that the server replaces is not lost
+type savingTokenSource struct {
+	client Client
+	source oauth2.TokenSource
+	mutex  sync.Mutex
+	saved  cachedToken
+}
+
+// Token returns the current token, saving it first if it was refreshed since it was last saved
+func (source *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := source.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if token.AccessToken == source.saved.AccessToken && token.RefreshToken == source.saved.RefreshToken {
+		return token, nil
+	}
+
+	source.saved.Token = *token
+	return token, source.client.saveToken(&source.saved)
 }
 
 // getTokenFromWeb uses Config to request a Token.
 // It returns the retrieved Token.
-func (client Client) getTokenFromWeb(writer io.Writer) (*oauth2.Token, error) {
+func (client Client) getTokenFromWeb(writer io.Writer) (*cachedToken, error) {
 	token := make(chan string)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("Your inbox should now be authorized.  You may close this window."))
@@ -72,10 +142,14 @@ func (client Client) getTokenFromWeb(writer io.Writer) (*oauth2.Token, error) {
 	}))
 	client.config.RedirectURL = server.URL
 
-	authURL := client.config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	options := append([]oauth2.AuthCodeOption{}, client.authOptions...)
+	if client.incremental() {
+		options = append(options, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	}
+
+	authURL := client.config.AuthCodeURL("state-token", options...)
 	fmt.Fprintf(writer, "Attempting to open %s in your browser\n", authURL)
-	cmd := client.cmdBuilder.New("", "xdg-open", authURL)
-	_, err := cmd.CombinedOutput()
+	err := openBrowser(client.cmdBuilder, authURL)
 	if err != nil {
 		fmt.Fprintf(writer, "Unable to open browser automatically: %v\nPlease open %s in your browser\n", err, authURL)
 	}
@@ -88,25 +162,68 @@ func (client Client) getTokenFromWeb(writer io.Writer) (*oauth2.Token, error) {
 		return nil, fmt.Errorf("Unable to retrieve token from web: %v", err)
 	}
 
-	return tok, nil
+	cached := &cachedToken{Token: *tok}
+	if client.incremental() {
+		cached.Scopes = client.config.Scopes
+	}
+
+	return cached, nil
+}
+
+// incremental is whether scopes beyond the base scopes are requested
+func (client Client) incremental() bool {
+	return len(client.config.Scopes) > client.baseScopes
+}
+
+// openBrowser opens url in the user's browser
+func openBrowser(cmdBuilder runner.Builder, url string) error {
+	_, err := cmdBuilder.New("", "xdg-open", url).CombinedOutput()
+	return err
 }
 
 // tokenFromFile retrieves a Token from a given file path.
 // It returns the retrieved Token and any read error encountered.
-func (client Client) tokenFromFile() (*oauth2.Token, error) {
+func (client Client) tokenFromFile() (*cachedToken, error) {
 	f, err := os.Open(client.tokenCacheFile)
 	if err != nil {
 		return nil, err
 	}
-	t := &oauth2.Token{}
+	t := &cachedToken{}
 	err = json.NewDecoder(f).Decode(t)
 	_ = f.Close()
 	return t, err
 }
 
+// hasScopes reports whether token was granted every scope the client requests
+func (client Client) hasScopes(token *cachedToken) bool {
+	granted := token.Scopes
+	if len(granted) == 0 {
+		granted = client.config.Scopes[:client.baseScopes]
+	}
+
+	for _, scope := range client.config.Scopes {
+		// Modifying messages includes reading them
+		if !hasString(granted, scope) && !(scope == gmail.GmailReadonlyScope && hasString(granted, gmail.GmailModifyScope)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
 // saveToken uses a file path to create a file and store the
 // token in it.
-func (client Client) saveToken(token *oauth2.Token) error {
+func (client Client) saveToken(token *cachedToken) error {
 	f, err := os.OpenFile(client.tokenCacheFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("Unable to cache oauth token: %v", err)
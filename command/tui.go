@@ -0,0 +1,371 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/urfave/cli"
+)
+
+const (
+	tuiHelp = "j/k move  enter open  r mark read  a archive  R refresh  q quit"
+	// tuiChromeLines is the number of lines that aren't used for the message list
+	tuiChromeLines = 6
+	// tuiPreviewLines is the number of lines used to preview the selected message
+	tuiPreviewLines = 3
+)
+
+// tui is an interactive list of unread messages
+type tui struct {
+	srv          *gmail.Service
+	fetcher      *messageFetcher
+	cmdBuilder   runner.Builder
+	accountIndex int
+	limit        int
+	writer       io.Writer
+	width        int
+	height       int
+	counts       []unreadCount
+	messages     []messageSummary
+	cursor       int
+	offset       int
+	status       string
+	refreshed    time.Time
+	// user is the mailbox that is shown, and address is its email address
+	user    string
+	address string
+	// labels are the labels of the messages that are shown
+	labels []string
+}
+
+// CmdTUI shows the unread messages in an interactive terminal interface
+func CmdTUI(cmdBuilder runner.Builder) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.NArg() != 0 {
+			return cli.NewExitError("Usage: \"unreadChecker tui\"", 1)
+		}
+
+		err := checkFlags(c)
+		if err != nil {
+			return err
+		}
+
+		srv, httpClient, err := getService(c, cmdBuilder, gmail.GmailModifyScope)
+		if err != nil {
+			return err
+		}
+
+		width, height := terminalSize(Stdin)
+		ui := &tui{
+			srv:          srv,
+			fetcher:      newMessageFetcher(srv, httpClient, "From", "Subject", "Date"),
+			cmdBuilder:   cmdBuilder,
+			user:         gmailUser(c),
+			labels:       countedLabels(c, defaultLabel),
+			accountIndex: c.Int("accountIndex"),
+			limit:        c.Int("limit"),
+			writer:       c.App.Writer,
+			width:        width,
+			height:       height,
+		}
+
+		restore, err := enterRawMode(Stdin)
+		if err != nil {
+			return err
+		}
+
+		defer restore()
+		interval := c.Duration("interval")
+		if interval <= 0 {
+			interval = time.Minute
+		}
+
+		return ui.run(Stdin, interval)
+	}
+}
+
+// run shows the interface until q is pressed or input ends
+func (ui *tui) run(input io.Reader, interval time.Duration) error {
+	// Use the alternate screen and hide the cursor
+	fmt.Fprint(ui.writer, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(ui.writer, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go readKeys(input, keys, done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ui.refresh()
+	for {
+		ui.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || key == "q" || key == "ctrl-c" {
+				return nil
+			}
+
+			ui.handleKey(key)
+		case <-ticker.C:
+			ui.refresh()
+		}
+	}
+}
+
+// readKeys sends each key pressed to keys until input ends or done is closed
+func readKeys(input io.Reader, keys chan<- string, done <-chan struct{}) {
+	defer close(keys)
+	reader := bufio.NewReader(input)
+	for {
+		char, _, err := reader.ReadRune()
+		if err != nil {
+			return
+		}
+
+		key := string(char)
+		switch char {
+		case '\x1b':
+			// Arrow keys are sent as escape sequences
+			key = "escape"
+			if reader.Buffered() >= 2 {
+				sequence := make([]byte, 2)
+				_, _ = io.ReadFull(reader, sequence)
+				key = map[string]string{"[A": "up", "[B": "down"}[string(sequence)]
+			}
+		case '\r', '\n':
+			key = "enter"
+		case '\x03':
+			key = "ctrl-c"
+		}
+
+		select {
+		case keys <- key:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (ui *tui) handleKey(key string) {
+	switch key {
+	case "j", "down":
+		ui.move(1)
+	case "k", "up":
+		ui.move(-1)
+	case "R":
+		ui.refresh()
+	case "enter", "o":
+		ui.open()
+	case "r":
+		ui.removeLabel("UNREAD", "Marked as read")
+	case "a":
+		ui.removeLabel(defaultLabel, "Archived")
+	}
+}
+
+// refresh reloads the unread counts and messages
+func (ui *tui) refresh() {
+	ids, err := listUnreadLabels(ui.srv, ui.user, ui.labels, 0)
+	if err != nil {
+		ui.status = err.Error()
+		return
+	}
+
+	if ui.address == "" {
+		ui.address, err = gmailAddress(ui.srv, ui.user)
+		if err != nil {
+			ui.status = err.Error()
+			return
+		}
+	}
+
+	ui.counts = []unreadCount{{Account: ui.address, Label: defaultLabel, Unread: len(ids), Messages: len(ids), ids: ids}}
+	if ui.limit > 0 && len(ids) > ui.limit {
+		ids = ids[:ui.limit]
+	}
+
+	ui.messages = make([]messageSummary, 0, len(ids))
+	ui.status = ""
+	for index, result := range ui.fetcher.fetch(ui.user, ids) {
+		if result.err != nil {
+			ui.status = fmt.Sprintf("Unable to get message %s. %v", ids[index], result.err)
+			continue
+		}
+
+		ui.messages = append(ui.messages, newMessageSummary(result.message))
+	}
+
+	ui.refreshed = time.Now()
+	ui.move(0)
+}
+
+// move moves the cursor by delta messages, keeping it on the screen
+func (ui *tui) move(delta int) {
+	ui.cursor += delta
+	if ui.cursor >= len(ui.messages) {
+		ui.cursor = len(ui.messages) - 1
+	}
+
+	if ui.cursor < 0 {
+		ui.cursor = 0
+	}
+
+	rows := ui.listRows()
+	if ui.cursor < ui.offset {
+		ui.offset = ui.cursor
+	}
+
+	if ui.cursor >= ui.offset+rows {
+		ui.offset = ui.cursor - rows + 1
+	}
+}
+
+func (ui *tui) open() {
+	if len(ui.messages) == 0 {
+		return
+	}
+
+	if ui.user != defaultUser {
+		ui.status = "A delegated mailbox can not be opened in the browser"
+		return
+	}
+
+	err := openBrowser(ui.cmdBuilder, gmailMessageURL(ui.accountIndex, ui.messages[ui.cursor].ID))
+	if err != nil {
+		ui.status = fmt.Sprintf("Unable to open browser: %v", err)
+	}
+}
+
+// removeLabel removes label from the selected message and takes it off the list
+func (ui *tui) removeLabel(label, done string) {
+	if len(ui.messages) == 0 {
+		return
+	}
+
+	message := ui.messages[ui.cursor]
+	request := &gmail.BatchModifyMessagesRequest{Ids: []string{message.ID}, RemoveLabelIds: []string{label}}
+	err := ui.srv.Users.Messages.BatchModify(ui.user, request).Do()
+	if err != nil {
+		ui.status = fmt.Sprintf("Unable to modify message %s. %v", message.ID, err)
+		return
+	}
+
+	ui.messages = append(ui.messages[:ui.cursor], ui.messages[ui.cursor+1:]...)
+	for index := range ui.counts {
+		ui.counts[index].Unread--
+	}
+
+	ui.status = done
+	ui.move(0)
+}
+
+func (ui *tui) listRows() int {
+	rows := ui.height - tuiChromeLines
+	if rows < 1 {
+		return 1
+	}
+
+	return rows
+}
+
+// draw redraws the whole screen
+func (ui *tui) draw() {
+	lines := []string{ui.header()}
+	now := time.Now()
+	for row := 0; row < ui.listRows(); row++ {
+		index := ui.offset + row
+		if index >= len(ui.messages) {
+			lines = append(lines, "")
+			continue
+		}
+
+		line := ui.messageLine(ui.messages[index], now)
+		if index == ui.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, strings.Repeat("-", ui.width))
+	lines = append(lines, ui.preview()...)
+	status := ui.status
+	if status == "" {
+		status = tuiHelp
+	}
+
+	lines = append(lines, clip(status, ui.width))
+	fmt.Fprint(ui.writer, "\x1b[H\x1b[2J"+strings.Join(lines, "\r\n"))
+}
+
+func (ui *tui) header() string {
+	parts := []string{Name}
+	for _, count := range ui.counts {
+		parts = append(parts, fmt.Sprintf("%s %s: %d unread", count.Account, count.Label, count.Unread))
+	}
+
+	if !ui.refreshed.IsZero() {
+		parts = append(parts, "refreshed "+ui.refreshed.Format("15:04"))
+	}
+
+	return "\x1b[1m" + clip(strings.Join(parts, "  "), ui.width) + "\x1b[0m"
+}
+
+// clip cuts value down to at most width runes
+func clip(value string, width int) string {
+	runes := []rune(value)
+	if len(runes) <= width {
+		return value
+	}
+
+	return string(runes[:width])
+}
+
+func (ui *tui) messageLine(message messageSummary, now time.Time) string {
+	fromWidth := 24
+	subjectWidth := ui.width - fromWidth - 8
+	if subjectWidth < 10 {
+		subjectWidth = 10
+	}
+
+	return fmt.Sprintf(
+		"%4s  %-*s  %s",
+		formatAge(now.Sub(message.Date)),
+		fromWidth,
+		truncate(message.From, fromWidth),
+		truncate(message.Subject, subjectWidth),
+	)
+}
+
+// preview wraps the snippet of the selected message
+func (ui *tui) preview() []string {
+	lines := make([]string, tuiPreviewLines)
+	if len(ui.messages) == 0 {
+		lines[0] = "No unread messages"
+		return lines
+	}
+
+	line := 0
+	for _, word := range strings.Fields(ui.messages[ui.cursor].Snippet) {
+		if lines[line] != "" && len([]rune(lines[line]))+1+len([]rune(word)) > ui.width {
+			line++
+			if line == tuiPreviewLines {
+				break
+			}
+		}
+
+		if lines[line] != "" {
+			lines[line] += " "
+		}
+
+		lines[line] += word
+	}
+
+	return lines
+}
@@ -0,0 +1,108 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCmdTUI(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b", "c", "a"}}, messages: listTestMessages}
+	frame, err := runTUI(t, mock, "q")
+	assert.Nil(t, err)
+	lines := strings.Split(frame, "\r\n")
+	assert.Equal(t, 24, len(lines))
+	assert.Contains(t, lines[0], "unreadChecker  me@example.com INBOX: 3 unread  refreshed ")
+	assert.Contains(t, lines[1], "\x1b[7m")
+	assert.Contains(t, lines[1], "Bob <bob@example.com>     Build failed")
+	assert.Contains(t, lines[2], "carol@example.com         A very long subject")
+	assert.Contains(t, lines[3], "Lunch?")
+	assert.Equal(t, strings.Repeat("-", 80), lines[19])
+	assert.Equal(t, "The build failed again", lines[20])
+	assert.Equal(t, "j/k move  enter open  r mark read  a archive  R refresh  q quit", lines[23])
+}
+
+func TestCmdTUIMarkRead(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b", "c", "a"}}, messages: listTestMessages}
+	frame, err := runTUI(t, mock, "jjkr")
+	assert.Nil(t, err)
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"c"}, RemoveLabelIds: []string{"UNREAD"}}}, mock.modified)
+	lines := strings.Split(frame, "\r\n")
+	assert.Contains(t, lines[0], "me@example.com INBOX: 2 unread")
+	assert.NotContains(t, frame, "A very long subject")
+	assert.Contains(t, lines[2], "\x1b[7m")
+	assert.Contains(t, lines[2], "Lunch?")
+	assert.Equal(t, "Marked as read", lines[23])
+}
+
+func TestCmdTUIArchiveArrowKeys(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b", "c", "a"}}, messages: listTestMessages}
+	_, err := runTUI(t, mock, "\x1b[B\x1b[B\x1b[Aa")
+	assert.Nil(t, err)
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"c"}, RemoveLabelIds: []string{"INBOX"}}}, mock.modified)
+}
+
+func TestCmdTUIOpen(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b", "c", "a"}}, messages: listTestMessages}
+	_, err := runTUI(t, mock, "j\rq", runner.NewExpectedCommand("", regexp.QuoteMeta("xdg-open https://mail.google.com/mail/u/0/#all/c"), "", 0))
+	assert.Nil(t, err)
+}
+
+func TestCmdTUIRefresh(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b"}, {"c", "b"}}, messages: listTestMessages}
+	frame, err := runTUI(t, mock, "R")
+	assert.Nil(t, err)
+	assert.Contains(t, frame, "me@example.com INBOX: 2 unread")
+	assert.Contains(t, frame, "A very long subject")
+}
+
+func TestCmdTUIEmpty(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{}}}
+	frame, err := runTUI(t, mock, "jra\r")
+	assert.Nil(t, err)
+	assert.Contains(t, frame, "me@example.com INBOX: 0 unread")
+	assert.Contains(t, frame, "No unread messages")
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest(nil), mock.modified)
+}
+
+func TestCmdTUIUsage(t *testing.T) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	app, _, _, set := getBaseAppAndFlagSet(t, testFolder, "")
+	assert.Nil(t, set.Parse([]string{"foo"}))
+	assert.EqualError(t, command.CmdTUI(&runner.Test{})(cli.NewContext(app, set, nil)), `Usage: "unreadChecker tui"`)
+}
+
+// runTUI runs CmdTUI against mock with keys as input and returns the last screen drawn
+func runTUI(t *testing.T, mock *mockGmail, keys string, commands ...*runner.ExpectedCommand) (string, error) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	ts := mock.start()
+	defer ts.Close()
+	command.BasePath = ts.URL
+	command.Stdin = strings.NewReader(keys)
+	defer func() { command.Stdin = os.Stdin }()
+	cb := &runner.Test{ExpectedCommands: append([]*runner.ExpectedCommand{getBrowserCommand(t)}, commands...)}
+	app, writer, _, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	set.Int("limit", 100, "doc")
+	set.Duration("interval", time.Minute, "doc")
+	err := command.CmdTUI(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	output := writer.String()
+	assert.True(t, strings.HasSuffix(output, "\x1b[?25h\x1b[?1049l"))
+	frames := strings.Split(strings.TrimSuffix(output, "\x1b[?25h\x1b[?1049l"), "\x1b[H\x1b[2J")
+	return frames[len(frames)-1], err
+}
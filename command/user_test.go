@@ -0,0 +1,74 @@
+package command_test
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdCheckUser(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"d", "c", "b", "a"}}}
+	output, err := runUserCheck(t, mock, func(*flag.FlagSet) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "boss@example.com: 4\n", output)
+	assert.Equal(t, []string{"Boss@example.com"}, mock.delegates)
+}
+
+func TestCmdCheckUserJSON(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"d", "c", "b", "a"}}, threads: map[string]string{"c": "a"}}
+	output, err := runUserCheck(t, mock, func(set *flag.FlagSet) {
+		set.String("format", "json", "doc")
+		set.String("count", "both", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":4,"counts":[{"account":"boss@example.com","label":"INBOX","unread":4,"messages":4,"threads":3}]}`+"\n", output)
+	assert.Equal(t, []string{"Boss@example.com", "Boss@example.com"}, mock.delegates)
+}
+
+func TestCmdCheckUserStatusBar(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"b", "a"}}}
+	output, err := runUserCheck(t, mock, func(set *flag.FlagSet) {
+		set.String("format", "i3blocks", "doc")
+		set.Int("warnThreshold", 1, "doc")
+		set.String("warnColor", "#FFFF00", "doc")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "boss@example.com: 2\nboss@example.com: 2\n#FFFF00\n", output)
+}
+
+func TestCmdCheckUserNotify(t *testing.T) {
+	errOutput := runNotifyCheck(
+		t,
+		[][]string{{"a"}, {"b", "a"}},
+		func(set *flag.FlagSet) {
+			set.String("user", "Boss@example.com", "doc")
+		},
+		runner.NewExpectedCommand("", "notify-send --app-name=unreadChecker --icon=mail-unread 1 new message Bob &lt;bob@example.com&gt;: Second", "", 0),
+	)
+	assert.Equal(t, "", errOutput)
+}
+
+func TestCmdMarkReadUser(t *testing.T) {
+	mock := &mockGmail{t: t, checks: [][]string{{"a", "b", "c"}}, messages: listTestMessages}
+	output, _, err := runMarkRead(t, mock, nil, func(set *flag.FlagSet) {
+		set.String("user", "Boss@example.com", "doc")
+		set.Bool("yes", true, "doc")
+	})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(output, "\nMarked 3 messages in Boss@example.com as read\n"))
+	assert.Equal(t, []gmail.BatchModifyMessagesRequest{{Ids: []string{"a", "b", "c"}, RemoveLabelIds: []string{"UNREAD"}}}, mock.modified)
+	assert.Equal(t, "Boss@example.com", mock.delegates[len(mock.delegates)-1])
+}
+
+// runUserCheck runs CmdCheck for the delegated mailbox Boss@example.com against mock
+func runUserCheck(t *testing.T, mock *mockGmail, setFlags func(*flag.FlagSet)) (string, error) {
+	return runGmailCheck(t, mock, func(set *flag.FlagSet) {
+		set.String("user", "Boss@example.com", "doc")
+		setFlags(set)
+	})
+}
@@ -1,11 +1,65 @@
 package command_test
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
 
+	gmail "google.golang.org/api/gmail/v1"
+
+	"github.com/guywithnose/runner"
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli"
 )
 
+// runGmailCheck runs CmdCheck against mock with the flags from setFlags and returns the output after the OAuth prompt
+func runGmailCheck(t *testing.T, mock *mockGmail, setFlags func(*flag.FlagSet)) (string, error) {
+	output, _, err := runGmailCommand(t, command.CmdCheck, mock.start(), setFlags)
+	return output, err
+}
+
+// runGmailCommand runs cmd against the mock API ts with the flags from setFlags, expecting the OAuth prompt and then expectedCommands.
+// It closes ts and returns the output after the OAuth prompt and the error output.
+func runGmailCommand(
+	t *testing.T,
+	cmd func(runner.Builder) func(*cli.Context) error,
+	ts *httptest.Server,
+	setFlags func(*flag.FlagSet),
+	expectedCommands ...*runner.ExpectedCommand,
+) (string, string, error) {
+	testFolder := filepath.Join(os.TempDir(), "testUnreadChecker")
+	assert.Nil(t, os.MkdirAll(testFolder, 0777))
+	defer removeFile(t, testFolder)
+	defer ts.Close()
+	command.BasePath = ts.URL
+	cb := &runner.Test{ExpectedCommands: append([]*runner.ExpectedCommand{getBrowserCommand(t)}, expectedCommands...)}
+	app, writer, errWriter, set := getBaseAppAndFlagSet(t, testFolder, ts.URL)
+	setFlags(set)
+	err := cmd(cb)(cli.NewContext(app, set, nil))
+	assert.Equal(t, []*runner.ExpectedCommand{}, cb.ExpectedCommands)
+	assert.Equal(t, []error(nil), cb.Errors)
+	output := writer.String()
+	assert.Contains(t, output, "Attempting to open")
+	return output[strings.Index(output, "\n")+1:], errWriter.String(), err
+}
+
 func appWithTestWriters() (*cli.App, *bytes.Buffer, *bytes.Buffer) {
 	app := cli.NewApp()
 	writer := new(bytes.Buffer)
@@ -14,3 +68,312 @@ func appWithTestWriters() (*cli.App, *bytes.Buffer, *bytes.Buffer) {
 	app.ErrWriter = errWriter
 	return app, writer, errWriter
 }
+
+// mockGmail is a mock API whose unread message list is the next entry in checks on each call.
+// Once the sequence is exhausted the last entry is repeated.  Messages can be fetched by id, individually or in a batch.
+type mockGmail struct {
+	t             *testing.T
+	checks        [][]string
+	messages      map[string]*gmail.Message
+	batchDisabled bool
+	// rateLimited is how many times a message will be refused with a 429 before it is returned
+	rateLimited map[string]int
+	// threads maps message ids to thread ids.  Messages that aren't in it are in a thread of their own.
+	threads         map[string]string
+	mutex           sync.Mutex
+	check           int
+	unread          []string
+	authScopes      []string
+	queries         []string
+	modified        []gmail.BatchModifyMessagesRequest
+	batchRequests   int
+	messageRequests int
+	// delegates are the mailboxes other than the authenticated user's that were requested.
+	// The mock holds a single mailbox, so their requests are answered as if they were for it.
+	delegates []string
+}
+
+func getMockGoogleAPISequence(t *testing.T, checks [][]string, messages map[string]*gmail.Message) *httptest.Server {
+	return (&mockGmail{t: t, checks: checks, messages: messages}).start()
+}
+
+func (mock *mockGmail) start() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(mock.serveHTTP))
+}
+
+func (mock *mockGmail) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t := mock.t
+	b, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
+	if strings.Contains(r.URL.String(), "access_type=offline") {
+		mock.mutex.Lock()
+		mock.authScopes = append(mock.authScopes, r.FormValue("scope")+" "+r.FormValue("include_granted_scopes"))
+		mock.mutex.Unlock()
+		go func() {
+			_, err = http.Get(fmt.Sprintf("%s?code=foo", r.FormValue("redirect_uri")))
+			assert.Nil(t, err)
+		}()
+		return
+	}
+
+	if r.URL.Path == "/batch/gmail/v1" {
+		mock.serveBatch(w, r)
+		return
+	}
+
+	if serveProfile(t, w, r) {
+		return
+	}
+
+	if parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2); len(parts) == 2 && parts[0] != "me" {
+		mock.mutex.Lock()
+		mock.delegates = append(mock.delegates, parts[0])
+		mock.mutex.Unlock()
+		r.URL.Path = "/me/" + parts[1]
+	}
+
+	if r.URL.Path == "/me/messages/batchModify" {
+		request := gmail.BatchModifyMessagesRequest{}
+		assert.Nil(t, json.Unmarshal(b, &request))
+		mock.mutex.Lock()
+		mock.modified = append(mock.modified, request)
+		mock.mutex.Unlock()
+		w.WriteHeader(204)
+		return
+	}
+
+	if r.URL.Path == "/me/messages" {
+		labels := r.URL.Query()["labelIds"]
+		mock.mutex.Lock()
+		if query := r.URL.Query().Get("q"); query != "label:unread" {
+			mock.queries = append(mock.queries, query)
+		}
+
+		var ids []string
+		if len(labels) <= 1 {
+			ids = mock.checks[mock.check]
+			if mock.check < len(mock.checks)-1 {
+				mock.check++
+			}
+			mock.unread = ids
+		} else {
+			ids = mock.withLabels(labels)
+		}
+		mock.mutex.Unlock()
+
+		resp := gmail.ListMessagesResponse{Messages: []*gmail.Message{}}
+		for _, id := range ids {
+			resp.Messages = append(resp.Messages, &gmail.Message{Id: id})
+		}
+
+		writeJSON(t, w, resp)
+		return
+	}
+
+	if r.URL.Path == "/me/labels" {
+		mock.mutex.Lock()
+		resp := gmail.ListLabelsResponse{}
+		for _, label := range mock.labelIDs() {
+			resp.Labels = append(resp.Labels, &gmail.Label{Id: label, Name: label})
+		}
+		mock.mutex.Unlock()
+		writeJSON(t, w, resp)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/me/labels/") {
+		label := strings.TrimPrefix(r.URL.Path, "/me/labels/")
+		mock.mutex.Lock()
+		unread := len(mock.withLabels([]string{label}))
+		mock.mutex.Unlock()
+		writeJSON(t, w, gmail.Label{Id: label, Name: label, MessagesUnread: int64(unread)})
+		return
+	}
+
+	if r.URL.Path == "/me/threads" {
+		mock.serveThreads(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/me/messages/") {
+		id := strings.TrimPrefix(r.URL.Path, "/me/messages/")
+		mock.mutex.Lock()
+		mock.messageRequests++
+		rateLimited := mock.rateLimited[id] > 0
+		if rateLimited {
+			mock.rateLimited[id]--
+		}
+		mock.mutex.Unlock()
+
+		if rateLimited {
+			w.WriteHeader(429)
+			_, err = w.Write([]byte(`{"error":{"code":429,"message":"Too many concurrent requests for user","errors":[{"reason":"rateLimitExceeded"}]}}`))
+			assert.Nil(t, err)
+			return
+		}
+
+		message, ok := mock.messages[id]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+
+		writeJSON(t, w, message)
+		return
+	}
+
+	require.Equal(t, "foo", r.FormValue("code"))
+	response := url.Values{"access_token": []string{"fakeToken"}}
+	_, err = w.Write([]byte(response.Encode()))
+	assert.Nil(t, err)
+}
+
+// serveThreads lists the threads of the messages returned by the last message list
+func (mock *mockGmail) serveThreads(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	resp := gmail.ListThreadsResponse{Threads: []*gmail.Thread{}}
+	seen := map[string]bool{}
+	for _, id := range mock.withLabels(r.URL.Query()["labelIds"]) {
+		threadID, ok := mock.threads[id]
+		if !ok {
+			threadID = id
+		}
+
+		if !seen[threadID] {
+			seen[threadID] = true
+			resp.Threads = append(resp.Threads, &gmail.Thread{Id: threadID})
+		}
+	}
+
+	writeJSON(mock.t, w, resp)
+}
+
+// withLabels returns the unread messages of the current check that have every label but INBOX.
+// The caller must hold the mutex.
+func (mock *mockGmail) withLabels(labels []string) []string {
+	unread := mock.unread
+	if unread == nil {
+		unread = mock.checks[mock.check]
+	}
+
+	ids := []string{}
+	for _, id := range unread {
+		matches := true
+		for _, label := range labels {
+			if label != "INBOX" && (mock.messages[id] == nil || !containsString(mock.messages[id].LabelIds, label)) {
+				matches = false
+			}
+		}
+
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// labelIDs returns INBOX followed by the other labels on the messages, sorted
+func (mock *mockGmail) labelIDs() []string {
+	labels := []string{}
+	for _, message := range mock.messages {
+		for _, label := range message.LabelIds {
+			if label != "INBOX" && label != "UNREAD" && !containsString(labels, label) {
+				labels = append(labels, label)
+			}
+		}
+	}
+
+	sort.Strings(labels)
+	return append([]string{"INBOX"}, labels...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveBatch answers each request in a multipart batch by passing it to serveHTTP
+func (mock *mockGmail) serveBatch(w http.ResponseWriter, r *http.Request) {
+	t := mock.t
+	mock.mutex.Lock()
+	mock.batchRequests++
+	mock.mutex.Unlock()
+	if mock.batchDisabled {
+		w.WriteHeader(404)
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	require.Nil(t, err)
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	writer := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		assert.Equal(t, "application/http", part.Header.Get("Content-Type"))
+		request, err := http.ReadRequest(bufio.NewReader(part))
+		require.Nil(t, err)
+		recorder := httptest.NewRecorder()
+		mock.serveHTTP(recorder, request)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+		responsePart, err := writer.CreatePart(header)
+		require.Nil(t, err)
+		fmt.Fprintf(responsePart, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n", recorder.Code, http.StatusText(recorder.Code))
+		_, err = responsePart.Write(recorder.Body.Bytes())
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, writer.Close())
+}
+
+// testAddress is the address in the authenticated user's profile
+const testAddress = "me@example.com"
+
+// serveProfile answers requests for a mailbox's profile, which has the mailbox's own address when it is delegated.
+// It returns false for other requests.
+func serveProfile(t *testing.T, w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasSuffix(r.URL.Path, "/profile") {
+		return false
+	}
+
+	address := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/profile"))
+	if address == "me" {
+		address = testAddress
+	}
+
+	writeJSON(t, w, gmail.Profile{EmailAddress: address})
+	return true
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, value interface{}) {
+	bytes, err := json.Marshal(value)
+	assert.Nil(t, err)
+	_, err = w.Write(bytes)
+	assert.Nil(t, err)
+}
+
+func newTestMessage(id, from, subject string) *gmail.Message {
+	return &gmail.Message{
+		Id: id,
+		Payload: &gmail.MessagePart{
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: from},
+				{Name: "Subject", Value: subject},
+			},
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// inotifyMask is the changes to a directory that can change which messages are unread
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY
+
+// watchPaths uses inotify to send to changes whenever a file in one of dirs changes, until stop is called
+func watchPaths(dirs []string, changes chan<- struct{}, errWriter io.Writer) func() {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		fmt.Fprintf(errWriter, "Unable to watch for changes: %v\n", err)
+		return func() {}
+	}
+
+	// A non-blocking file is read through the runtime poller, so closing it ends the read below
+	file := os.NewFile(uintptr(fd), "inotify")
+	for _, dir := range dirs {
+		_, err = syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			fmt.Fprintf(errWriter, "Unable to watch %s: %v\n", dir, err)
+		}
+	}
+
+	go func() {
+		events := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			_, err := file.Read(events)
+			if err != nil {
+				return
+			}
+
+			notifyChange(changes)
+		}
+	}()
+
+	return func() { _ = file.Close() }
+}
@@ -0,0 +1,52 @@
+//go:build !linux
+
+package command
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// WatchPollInterval is how often directories are checked for changes where inotify is not available
+var WatchPollInterval = 5 * time.Second
+
+// watchPaths sends to changes whenever the modification time of one of dirs changes, until stop is called
+func watchPaths(dirs []string, changes chan<- struct{}, errWriter io.Writer) func() {
+	done := make(chan struct{})
+	modTimes := func() map[string]time.Time {
+		times := map[string]time.Time{}
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir); err == nil {
+				times[dir] = info.ModTime()
+			}
+		}
+
+		return times
+	}
+
+	go func() {
+		previous := modTimes()
+		ticker := time.NewTicker(WatchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			current := modTimes()
+			for dir, modTime := range current {
+				if !modTime.Equal(previous[dir]) {
+					notifyChange(changes)
+					break
+				}
+			}
+
+			previous = current
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the request body when a webhook secret is set
+const WebhookSignatureHeader = "X-UnreadChecker-Signature"
+
+// webhookSender delivers count changes to webhooks in the background
+type webhookSender struct {
+	urls      []string
+	secret    []byte
+	retries   int
+	backoff   time.Duration
+	client    *http.Client
+	errWriter io.Writer
+	logFile   string
+	logMutex  sync.Mutex
+	running   sync.WaitGroup
+}
+
+// webhookPayload is the body POSTed to webhooks when a count changes
+type webhookPayload struct {
+	Account       string   `json:"account"`
+	Label         string   `json:"label"`
+	PreviousCount int      `json:"previousCount"`
+	CurrentCount  int      `json:"currentCount"`
+	NewMessageIDs []string `json:"newMessageIds"`
+}
+
+// webhookDelivery is a single entry in the delivery log
+type webhookDelivery struct {
+	Time       time.Time `json:"time"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func newWebhookSender(c *cli.Context, errWriter io.Writer) *webhookSender {
+	if len(c.StringSlice("webhook")) == 0 {
+		return nil
+	}
+
+	return &webhookSender{
+		urls:      c.StringSlice("webhook"),
+		secret:    []byte(c.String("webhookSecret")),
+		retries:   c.Int("webhookRetries"),
+		backoff:   c.Duration("webhookBackoff"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+		errWriter: errWriter,
+		logFile:   c.String("webhookLog"),
+	}
+}
+
+// send starts delivering every changed count to every webhook
+func (sender *webhookSender) send(previous, current []unreadCount) {
+	for _, change := range findChanges(previous, current) {
+		body, _ := json.Marshal(webhookPayload{
+			Account:       change.current.Account,
+			Label:         change.current.Label,
+			PreviousCount: change.previous.Unread,
+			CurrentCount:  change.current.Unread,
+			NewMessageIDs: newMessageIDs(change.previous, change.current),
+		})
+
+		for _, url := range sender.urls {
+			sender.running.Add(1)
+			go sender.deliver(url, body)
+		}
+	}
+}
+
+// deliver POSTs body to url, retrying with exponential backoff on network errors and retryable responses
+func (sender *webhookSender) deliver(url string, body []byte) {
+	defer sender.running.Done()
+	backoff := sender.backoff
+	var err error
+	for attempt := 1; attempt <= sender.retries+1; attempt++ {
+		if attempt != 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = sender.post(url, body, attempt)
+		if err == nil || !retry {
+			break
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(sender.errWriter, "Webhook delivery to %s failed: %v\n", url, err)
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth retrying
+func (sender *webhookSender) post(url string, body []byte, attempt int) (bool, error) {
+	delivery := webhookDelivery{Time: time.Now(), URL: url, Attempt: attempt}
+	defer sender.log(&delivery)
+
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("%s/%s", Name, Version))
+	if len(sender.secret) != 0 {
+		mac := hmac.New(sha256.New, sender.secret)
+		_, _ = mac.Write(body)
+		request.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := sender.client.Do(request)
+	if err != nil {
+		delivery.Error = err.Error()
+		return true, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	delivery.StatusCode = response.StatusCode
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("%s", response.Status)
+	delivery.Error = err.Error()
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+// log appends a delivery attempt to the delivery log as a line of JSON
+func (sender *webhookSender) log(delivery *webhookDelivery) {
+	if sender.logFile == "" {
+		return
+	}
+
+	sender.logMutex.Lock()
+	defer sender.logMutex.Unlock()
+	f, err := os.OpenFile(sender.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintf(sender.errWriter, "Unable to write webhook log: %v\n", err)
+		return
+	}
+
+	err = json.NewEncoder(f).Encode(delivery)
+	_ = f.Close()
+	if err != nil {
+		fmt.Fprintf(sender.errWriter, "Unable to write webhook log: %v\n", err)
+	}
+}
+
+// wait blocks until all deliveries have finished
+func (sender *webhookSender) wait() {
+	sender.running.Wait()
+}
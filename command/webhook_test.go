@@ -0,0 +1,163 @@
+package command_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guywithnose/unreadChecker/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+type webhookReceiver struct {
+	mutex      sync.Mutex
+	bodies     []string
+	signatures []string
+	statuses   []int
+}
+
+func TestCmdCheckWebhook(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := receiver.start(t)
+	defer ts.Close()
+	errOutput, log := runWebhookCheck(t, [][]string{{"a"}, {"c", "b", "a"}, {}}, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{ts.URL}, "webhook", "doc")
+		set.String("webhookSecret", "shh", "doc")
+	})
+	assert.Equal(t, "", errOutput)
+	assert.Equal(
+		t,
+		[]string{
+			`{"account":"me@example.com","label":"INBOX","previousCount":1,"currentCount":3,"newMessageIds":["c","b"]}`,
+			`{"account":"me@example.com","label":"INBOX","previousCount":3,"currentCount":0,"newMessageIds":[]}`,
+		},
+		receiver.bodies,
+	)
+	for index, body := range receiver.bodies {
+		mac := hmac.New(sha256.New, []byte("shh"))
+		_, _ = mac.Write([]byte(body))
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), receiver.signatures[index])
+	}
+
+	assert.Equal(t, 2, len(log))
+	assert.Equal(t, ts.URL, log[0]["url"])
+	assert.Equal(t, float64(1), log[0]["attempt"])
+	assert.Equal(t, float64(200), log[0]["statusCode"])
+}
+
+func TestCmdCheckWebhookRetry(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{500, 429}}
+	ts := receiver.start(t)
+	defer ts.Close()
+	errOutput, log := runWebhookCheck(t, [][]string{{"a"}, {"b", "a"}}, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{ts.URL}, "webhook", "doc")
+	})
+	assert.Equal(t, "", errOutput)
+	assert.Equal(t, 3, len(receiver.bodies))
+	assert.Equal(t, []string{"", "", ""}, receiver.signatures)
+	assert.Equal(t, 3, len(log))
+	assert.Equal(t, "500 Internal Server Error", log[0]["error"])
+	assert.Equal(t, "429 Too Many Requests", log[1]["error"])
+	assert.Equal(t, float64(3), log[2]["attempt"])
+	assert.Equal(t, float64(200), log[2]["statusCode"])
+}
+
+func TestCmdCheckWebhookRetriesExhausted(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{500, 500, 500}}
+	ts := receiver.start(t)
+	defer ts.Close()
+	errOutput, log := runWebhookCheck(t, [][]string{{"a"}, {"b", "a"}}, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{ts.URL}, "webhook", "doc")
+		assert.Nil(t, set.Set("webhookRetries", "2"))
+	})
+	assert.Equal(t, "Webhook delivery to "+ts.URL+" failed: 500 Internal Server Error\n", errOutput)
+	assert.Equal(t, 3, len(receiver.bodies))
+	assert.Equal(t, 3, len(log))
+}
+
+func TestCmdCheckWebhookNoRetryOnClientError(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{400}}
+	ts := receiver.start(t)
+	defer ts.Close()
+	errOutput, log := runWebhookCheck(t, [][]string{{"a"}, {"b", "a"}}, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{ts.URL}, "webhook", "doc")
+	})
+	assert.Equal(t, "Webhook delivery to "+ts.URL+" failed: 400 Bad Request\n", errOutput)
+	assert.Equal(t, 1, len(receiver.bodies))
+	assert.Equal(t, 1, len(log))
+}
+
+func TestCmdCheckWebhookUnreachable(t *testing.T) {
+	receiver := &webhookReceiver{}
+	ts := receiver.start(t)
+	url := ts.URL
+	ts.Close()
+	errOutput, log := runWebhookCheck(t, [][]string{{"a"}, {"b", "a"}}, func(set *flag.FlagSet) {
+		set.Var(&cli.StringSlice{url}, "webhook", "doc")
+		assert.Nil(t, set.Set("webhookRetries", "1"))
+	})
+	assert.Contains(t, errOutput, "Webhook delivery to "+url+" failed: ")
+	assert.Contains(t, errOutput, "connection refused")
+	assert.Equal(t, 2, len(log))
+}
+
+func (receiver *webhookReceiver) start(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		receiver.bodies = append(receiver.bodies, string(body))
+		receiver.signatures = append(receiver.signatures, r.Header.Get(command.WebhookSignatureHeader))
+		if len(receiver.statuses) != 0 {
+			w.WriteHeader(receiver.statuses[0])
+			receiver.statuses = receiver.statuses[1:]
+		}
+	}))
+}
+
+// runWebhookCheck runs one watch iteration per entry in checks and returns the error output and the delivery log
+func runWebhookCheck(t *testing.T, checks [][]string, setFlags func(*flag.FlagSet)) (string, []map[string]interface{}) {
+	logFolder, err := ioutil.TempDir("", "testUnreadCheckerWebhook")
+	assert.Nil(t, err)
+	defer removeFile(t, logFolder)
+	logFile := filepath.Join(logFolder, "webhook.log")
+	command.MaxWatchIterations = len(checks)
+	defer func() { command.MaxWatchIterations = 0 }()
+	_, errOutput, err := runGmailCommand(t, command.CmdCheck, getMockGoogleAPISequence(t, checks, testMessages), func(set *flag.FlagSet) {
+		set.Bool("watch", true, "doc")
+		set.Duration("interval", 50*time.Millisecond, "doc")
+		set.Int("webhookRetries", 3, "doc")
+		set.Duration("webhookBackoff", time.Millisecond, "doc")
+		set.String("webhookLog", logFile, "doc")
+		setFlags(set)
+	})
+	assert.Nil(t, err)
+
+	log := []map[string]interface{}{}
+	contents, _ := ioutil.ReadFile(logFile)
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		log = append(log, entry)
+	}
+
+	return errOutput, log
+}